@@ -0,0 +1,211 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The album artist of an album whose tracks have different artists.
+const variousArtists = "Various Artists"
+
+// An Album is the items in one directory. `Directory`, `Tracks`, `Cover`, and
+// `Documents` are escaped web pathnames, like `ItemInfo.Pathname`.
+type Album struct {
+	Directory string `json:"directory"`
+	Name      string `json:"name"`
+	Artist    string `json:"artist"`
+	// The pathnames of the items, in disc and track order.
+	Tracks    []string `json:"tracks"`
+	Duration  float64  `json:"duration,omitempty"`
+	FirstYear int      `json:"first_year,omitempty"`
+	LastYear  int      `json:"last_year,omitempty"`
+	Cover     string   `json:"cover,omitempty"`
+	Documents []string `json:"documents,omitempty"`
+}
+
+// An Artist is everyone credited with the same `ItemInfo.Artist`. `Albums` are
+// the directories of the albums they appear on, in catalog order.
+type Artist struct {
+	Name     string   `json:"name"`
+	Albums   []string `json:"albums"`
+	Tracks   int      `json:"tracks"`
+	Duration float64  `json:"duration,omitempty"`
+}
+
+// getMostCommonValue returns the most common non-empty value of `field` in
+// `items`, preferring the first to appear in a tie.
+func getMostCommonValue(items ItemInfos, field func(*ItemInfo) string) string {
+	counts := map[string]int{}
+	best := ""
+	for i := range items {
+		v := field(&items[i])
+		if v == "" {
+			continue
+		}
+		counts[v]++
+		if counts[v] > counts[best] {
+			best = v
+		}
+	}
+	return best
+}
+
+// getNumber returns the value of the decimal digits `s`, or 0 if there are none.
+func getNumber(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// readAlbumFiles sets `a.Cover` and `a.Documents` from the files in its
+// directory, which is in `roots`.
+func (a *Album) readAlbumFiles(roots musicRoots) {
+	a.Cover, a.Documents = "", nil
+	dir, ok := roots.resolve(unescapePathname(a.Directory))
+	if !ok {
+		return
+	}
+	for _, extension := range coverExtensions {
+		if _, e := os.Stat(filepath.Join(dir, "cover"+extension)); e == nil {
+			a.Cover = path.Join(a.Directory, "cover"+extension)
+			break
+		}
+	}
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && isDocumentPathname(entry.Name()) {
+			a.Documents = append(a.Documents, path.Join(a.Directory, pathnameEscape(entry.Name())))
+		}
+	}
+}
+
+// newAlbum returns the album of `items`, which are all in `dir`. Its artist is
+// the album artist in the tags if they agree, and otherwise the artist of every
+// item, or `variousArtists` if it is a compilation or they differ.
+func newAlbum(dir string, items ItemInfos) Album {
+	a := Album{Directory: dir}
+	a.Name = getMostCommonValue(items, func(i *ItemInfo) string { return i.Album })
+	albumArtists := getDistinctValues(items, func(i *ItemInfo) string {
+		if i.Tags == nil {
+			return ""
+		}
+		return strings.TrimSpace(i.Tags.AlbumArtist)
+	})
+	compilation := false
+	for _, item := range items {
+		compilation = compilation || (item.Tags != nil && item.Tags.Compilation)
+	}
+	artists := getDistinctValues(items, func(i *ItemInfo) string { return i.Artist })
+	if len(albumArtists) == 1 {
+		a.Artist = albumArtists[0]
+	} else if compilation || len(artists) > 1 {
+		a.Artist = variousArtists
+	} else if len(artists) == 1 {
+		a.Artist = artists[0]
+	}
+
+	sorted := append(ItemInfos{}, items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		di, dj := getNumber(sorted[i].NormalizedDisc), getNumber(sorted[j].NormalizedDisc)
+		if di != dj {
+			return di < dj
+		}
+		return getNumber(sorted[i].NormalizedTrack) < getNumber(sorted[j].NormalizedTrack)
+	})
+	for _, item := range sorted {
+		a.Tracks = append(a.Tracks, item.Pathname)
+		a.Duration += item.Duration
+		if year := getNumber(item.NormalizedYear); year != 0 {
+			if a.FirstYear == 0 || year < a.FirstYear {
+				a.FirstYear = year
+			}
+			if year > a.LastYear {
+				a.LastYear = year
+			}
+		}
+	}
+	return a
+}
+
+// aggregate sets `c.Albums` and `c.Artists` from `c.ItemInfos`. The cover and
+// documents of each album are read from `roots`, unless `previous` has the
+// album; then they are copied from there.
+func (c *Catalog) aggregate(roots musicRoots, previous map[string]*Album) {
+	c.Albums, c.Artists = nil, nil
+	dirs, byDir := groupItemsByDirectory(c.ItemInfos)
+	for _, dir := range dirs {
+		a := newAlbum(dir, byDir[dir])
+		if p, ok := previous[dir]; ok {
+			a.Cover, a.Documents = p.Cover, p.Documents
+		} else {
+			a.readAlbumFiles(roots)
+		}
+		c.Albums = append(c.Albums, a)
+	}
+
+	byName := map[string]*Artist{}
+	// The directories already in each artist's `Albums`. An artist's tracks
+	// need not be together in walk order, e.g. if an album has subdirectories.
+	seen := map[string]map[string]bool{}
+	var names []string
+	for _, item := range c.ItemInfos {
+		a, ok := byName[item.Artist]
+		if !ok {
+			a = &Artist{Name: item.Artist}
+			byName[item.Artist] = a
+			seen[item.Artist] = map[string]bool{}
+			names = append(names, item.Artist)
+		}
+		dir := path.Dir(item.Pathname)
+		if !seen[item.Artist][dir] {
+			seen[item.Artist][dir] = true
+			a.Albums = append(a.Albums, dir)
+		}
+		a.Tracks++
+		a.Duration += item.Duration
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return normalizeStringForSearch(names[i]) < normalizeStringForSearch(names[j])
+	})
+	for _, name := range names {
+		c.Artists = append(c.Artists, *byName[name])
+	}
+}
+
+// byDirectory returns a map from each album's directory to the album.
+func (c *Catalog) byDirectory() map[string]*Album {
+	albums := make(map[string]*Album, len(c.Albums))
+	for i := range c.Albums {
+		albums[c.Albums[i].Directory] = &c.Albums[i]
+	}
+	return albums
+}
+
+// findAlbum returns the album in `dir`, or nil if there is none.
+func (c *Catalog) findAlbum(dir string) *Album {
+	for i := range c.Albums {
+		if c.Albums[i].Directory == dir {
+			return &c.Albums[i]
+		}
+	}
+	return nil
+}
+
+// findArtist returns the artist named `name`, or nil if there is none.
+func (c *Catalog) findArtist(name string) *Artist {
+	for i := range c.Artists {
+		if c.Artists[i].Name == name {
+			return &c.Artists[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http/httptest"
+	"path"
+	"testing"
+)
+
+func TestCatalogAggregate(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	writeTestFile(t, path.Join(root, "Band/Album/2-01 Four.mp3"), "four")
+	writeTestFile(t, path.Join(root, "Band/Album/1-10 Three.mp3"), "three")
+	writeTestFile(t, path.Join(root, "Band/Album/1-02 Two.mp3"), "two")
+	writeTestFile(t, path.Join(root, "Band/Album/cover.jpg"), "cover")
+	writeTestFile(t, path.Join(root, "Band/Album/notes.pdf"), "notes")
+	writeTestFile(t, path.Join(root, "Band/Live/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(root, "Zebra/Single/01 One.mp3"), "one")
+
+	c, _ := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 2})
+	c.ItemInfos[0].Year, c.ItemInfos[0].NormalizedYear = "1999", "1999"
+	c.ItemInfos[1].Year, c.ItemInfos[1].NormalizedYear = "2001", "2001"
+	c.ItemInfos[0].Duration, c.ItemInfos[1].Duration = 60, 90
+	c.aggregate(musicRoots{{Pathname: root}}, nil)
+
+	if len(c.Albums) != 3 {
+		t.Fatalf("expected 3 albums, got %d", len(c.Albums))
+	}
+	a := c.Albums[0]
+	expected := []string{"Band/Album/1-02%20Two.mp3", "Band/Album/1-10%20Three.mp3", "Band/Album/2-01%20Four.mp3"}
+	if a.Directory != "Band/Album" || a.Name != "Album" || a.Artist != "Band" || !stringSlicesEqual(a.Tracks, expected) {
+		t.Errorf("unexpected album %+v", a)
+	}
+	if a.Duration != 150 || a.FirstYear != 1999 || a.LastYear != 2001 {
+		t.Errorf("expected 150 seconds from 1999 to 2001, got %+v", a)
+	}
+	if a.Cover != "Band/Album/cover.jpg" || !stringSlicesEqual(a.Documents, []string{"Band/Album/notes.pdf"}) {
+		t.Errorf("expected the cover and notes, got %q and %q", a.Cover, a.Documents)
+	}
+
+	if len(c.Artists) != 2 || c.Artists[0].Name != "Band" || c.Artists[0].Tracks != 4 || !stringSlicesEqual(c.Artists[0].Albums, []string{"Band/Album", "Band/Live"}) {
+		t.Errorf("unexpected artists %+v", c.Artists)
+	}
+
+	h := &httpHandler{Roots: musicRoots{{Pathname: root}}, Catalog: newLiveCatalog(c), Logger: logger}
+	w := httptest.NewRecorder()
+	h.handleAlbums(w, httptest.NewRequest("GET", "/albums/Band/Album", nil))
+	var album struct {
+		Name  string
+		Items ItemInfos
+	}
+	if e := json.Unmarshal(w.Body.Bytes(), &album); e != nil {
+		t.Fatal(e)
+	}
+	if album.Name != "Album" || len(album.Items) != 3 || album.Items[0].Name != "Two" {
+		t.Errorf("unexpected album %+v", album)
+	}
+
+	w = httptest.NewRecorder()
+	h.handleAlbums(w, httptest.NewRequest("GET", "/albums?q=one", nil))
+	var albums []Album
+	if e := json.Unmarshal(w.Body.Bytes(), &albums); e != nil {
+		t.Fatal(e)
+	}
+	if len(albums) != 2 || albums[0].Directory != "Band/Live" || albums[1].Directory != "Zebra/Single" {
+		t.Errorf("unexpected albums %+v", albums)
+	}
+
+	w = httptest.NewRecorder()
+	h.handleArtists(w, httptest.NewRequest("GET", "/artists/Nobody", nil))
+	if w.Code != 404 {
+		t.Errorf("expected 404 for an unknown artist, got %d", w.Code)
+	}
+}
+
+func TestCatalogAggregateInterleavedAlbums(t *testing.T) {
+	c := &Catalog{ItemInfos: ItemInfos{
+		{Pathname: "Band/Album/01%20One.mp3", Artist: "Band"},
+		{Pathname: "Band/Album/Bonus/01%20Extra.mp3", Artist: "Band"},
+		{Pathname: "Band/Album/02%20Two.mp3", Artist: "Band"},
+	}}
+	c.aggregate(nil, nil)
+	if len(c.Artists) != 1 || !stringSlicesEqual(c.Artists[0].Albums, []string{"Band/Album", "Band/Album/Bonus"}) {
+		t.Errorf("unexpected artists %+v", c.Artists)
+	}
+
+	h := &httpHandler{Catalog: newLiveCatalog(c), Logger: log.New(io.Discard, "", 0)}
+	w := httptest.NewRecorder()
+	h.handleArtists(w, httptest.NewRequest("GET", "/artists/", nil))
+	var artists []Artist
+	if e := json.Unmarshal(w.Body.Bytes(), &artists); e != nil {
+		t.Fatal(e)
+	}
+	if len(artists) != 1 || artists[0].Name != "Band" {
+		t.Errorf("expected the list of artists, got %+v", artists)
+	}
+}
@@ -4,114 +4,438 @@
 package main
 
 import (
+	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
-	"id3"
 	"io"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Catalog struct {
 	ItemInfos
+	// Derived from `ItemInfos` by `aggregate`.
+	Albums  []Album
+	Artists []Artist
+	// Maps item IDs to indexes in `ItemInfos`, for `findByID`. Built by
+	// `indexIDs` when the catalog goes live; gob leaves it out.
+	idIndexes map[string]int
 }
 
-func (c *Catalog) writeToFile(pathname string) error {
-	w, e := os.Create(pathname)
+// liveCatalog holds the catalog that the server is currently serving. Readers
+// get a consistent snapshot with `Load`. Writers never modify a stored catalog;
+// they replace it entirely with `update`, so that in-flight requests finish
+// against the catalog they started with.
+type liveCatalog struct {
+	catalog atomic.Pointer[Catalog]
+	mutex   sync.Mutex
+}
+
+func newLiveCatalog(c *Catalog) *liveCatalog {
+	var l liveCatalog
+	c.indexIDs()
+	l.catalog.Store(c)
+	return &l
+}
+
+func (l *liveCatalog) Load() *Catalog {
+	return l.catalog.Load()
+}
+
+// update replaces the catalog with the result of calling `f` on the current
+// one. Calls to `update` are serialized, so `f` sees the result of any
+// previous update.
+func (l *liveCatalog) update(f func(*Catalog) *Catalog) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	c := f(l.catalog.Load())
+	c.indexIDs()
+	l.catalog.Store(c)
+}
+
+// reload reads the catalog at `pathname` and makes it the live catalog.
+func (l *liveCatalog) reload(pathname string) (*Catalog, error) {
+	c, e := readCatalogFromFile(pathname)
 	if e != nil {
+		return nil, e
+	}
+	l.update(func(*Catalog) *Catalog { return c })
+	return c, nil
+}
+
+const (
+	catalogBasename = "catalog.gobs.gz"
+)
+
+// byPathname returns a map from each item's `Pathname` to the item.
+func (c *Catalog) byPathname() map[string]*ItemInfo {
+	items := make(map[string]*ItemInfo, len(c.ItemInfos))
+	for i := range c.ItemInfos {
+		items[c.ItemInfos[i].Pathname] = &c.ItemInfos[i]
+	}
+	return items
+}
+
+// byID returns a map from each item ID to the item with that ID. Items without
+// an ID are left out. IDs are unique (see `assignUniqueIDs`), except in
+// catalogs from before version 11; then the first item with each ID wins.
+func (c *Catalog) byID() map[string]*ItemInfo {
+	items := make(map[string]*ItemInfo, len(c.ItemInfos))
+	for i := range c.ItemInfos {
+		item := &c.ItemInfos[i]
+		if _, ok := items[item.ID]; !ok && item.ID != "" {
+			items[item.ID] = item
+		}
+	}
+	return items
+}
+
+// indexIDs builds `c.idIndexes`, unless it already has. As with `byID`, the
+// first item with each ID wins.
+func (c *Catalog) indexIDs() {
+	if c.idIndexes != nil {
+		return
+	}
+	indexes := make(map[string]int, len(c.ItemInfos))
+	for i := range c.ItemInfos {
+		if _, ok := indexes[c.ItemInfos[i].ID]; !ok && c.ItemInfos[i].ID != "" {
+			indexes[c.ItemInfos[i].ID] = i
+		}
+	}
+	c.idIndexes = indexes
+}
+
+// findByID returns the item whose ID is `id`, or nil if there is none. It is
+// quick for live catalogs, which are indexed; others are searched.
+func (c *Catalog) findByID(id string) *ItemInfo {
+	if id == "" {
+		return nil
+	}
+	if c.idIndexes != nil {
+		if i, ok := c.idIndexes[id]; ok {
+			return &c.ItemInfos[i]
+		}
+		return nil
+	}
+	for i := range c.ItemInfos {
+		if c.ItemInfos[i].ID == id {
+			return &c.ItemInfos[i]
+		}
+	}
+	return nil
+}
+
+// Catalog files are gzipped, and begin with `catalogMagic` and a big-endian
+// uint32 version number. From version 2, the SHA-256 checksum of the rest of
+// the data follows. Then comes the gob-encoded `Catalog`. Version 0 files
+// predate the header, and are just the gob.
+var catalogMagic = [8]byte{'B', 'e', 'a', 'n', 'C', 'a', 't', 0}
+
+const catalogVersion = 11
+
+// catalogMigrations[v] upgrades a catalog read from a version v file to
+// version v + 1. There must be exactly `catalogVersion` migrations.
+var catalogMigrations = []func(*Catalog) error{
+	// 0 → 1: Only the header changed.
+	func(*Catalog) error { return nil },
+	// 1 → 2: Only the header changed.
+	func(*Catalog) error { return nil },
+	// 2 → 3: Items gained `AudioProperties`. They come from the audio stream,
+	// which the catalog never recorded. Mark them missing, so that the next
+	// scan reads them, but not the tags, from unchanged files.
+	func(c *Catalog) error {
+		for i := range c.ItemInfos {
+			c.ItemInfos[i].MissingProperties = true
+		}
+		return nil
+	},
+	// 3 → 4: Items gained `Added`. The modification time is the best guess we
+	// have.
+	func(c *Catalog) error {
+		for i := range c.ItemInfos {
+			c.ItemInfos[i].Added = c.ItemInfos[i].ModTime
+		}
+		return nil
+	},
+	// 4 → 5: Catalogs gained `Albums` and `Artists`. Covers and documents are
+	// left out until the next scan, because we don't know where the files are.
+	func(c *Catalog) error {
+		c.aggregate(nil, nil)
+		return nil
+	},
+	// 5 → 6: FLAC tags are read. Forget when FLAC files were modified, so that
+	// the next scan reads them again.
+	func(c *Catalog) error {
+		forgetModTimes(c, ".flac")
+		return nil
+	},
+	// 6 → 7: MP4 tags are read. Tags also gained album artists and compilation
+	// flags, but items work without them: ID3 files are read again for 9 → 10,
+	// and FLAC files get them when they next change.
+	func(c *Catalog) error {
+		forgetModTimes(c, ".m4a", ".m4v", ".mov", ".mp4")
+		return nil
+	},
+	// 7 → 8: Ogg tags are read.
+	func(c *Catalog) error {
+		forgetModTimes(c, ".ogg")
+		return nil
+	},
+	// 8 → 9: Items store their tags as `Metadata` in `Tags`, rather than as
+	// `id3.File` in `File`. Convert them.
+	func(c *Catalog) error {
+		for i := range c.ItemInfos {
+			item := &c.ItemInfos[i]
+			if item.File != nil {
+				item.Tags = getLegacyMetadata(item.Pathname, item.File)
+				item.File = nil
+			}
+		}
+		return nil
+	},
+	// 9 → 10: ID3v2 header flags, unsynchronisation, extended headers, and
+	// compressed frames are understood. Tags that used them were misread or not
+	// read at all, and the catalog doesn't record which did, so read again the
+	// files whose tags were ID3, or could not be read. That is most of a typical
+	// library, as the `catalog` help says.
+	func(c *Catalog) error {
+		for i := range c.ItemInfos {
+			if tags := c.ItemInfos[i].Tags; tags == nil || strings.HasPrefix(tags.Format, "ID3") {
+				c.ItemInfos[i].FileModTime = time.Time{}
+			}
+		}
+		return nil
+	},
+	// 10 → 11: Items with identical audio no longer share an ID.
+	func(c *Catalog) error {
+		c.assignUniqueIDs(&Catalog{})
+		return nil
+	},
+}
+
+// forgetModTimes zeroes the `FileModTime` of the items in `c` with any of the
+// given `extensions`, so that the next scan reads their files again.
+func forgetModTimes(c *Catalog, extensions ...string) {
+	for i := range c.ItemInfos {
+		extension := getBasenameExtension(c.ItemInfos[i].Pathname)
+		for _, x := range extensions {
+			if extension == x {
+				c.ItemInfos[i].FileModTime = time.Time{}
+			}
+		}
+	}
+}
+
+func (c *Catalog) write(w io.Writer) error {
+	var payload bytes.Buffer
+	if e := gob.NewEncoder(&payload).Encode(c); e != nil {
 		return e
 	}
+	checksum := sha256.Sum256(payload.Bytes())
+
 	zw, e := gzip.NewWriterLevel(w, 9)
 	if e != nil {
 		return e
 	}
-	if e := gob.NewEncoder(zw).Encode(c); e != nil {
+	if _, e := zw.Write(catalogMagic[:]); e != nil {
+		return e
+	}
+	if e := binary.Write(zw, binary.BigEndian, uint32(catalogVersion)); e != nil {
 		return e
 	}
-	if e := zw.Close(); e != nil {
+	if _, e := zw.Write(checksum[:]); e != nil {
 		return e
 	}
-	return w.Close()
+	if _, e := zw.Write(payload.Bytes()); e != nil {
+		return e
+	}
+	return zw.Close()
 }
 
-const (
-	catalogBasename = "catalog.gobs.gz"
-	eraseLine       = "\033[2K\r"
-)
+func getBackupPathname(pathname string) string {
+	return pathname + ".bak"
+}
 
-func shouldSkipFile(info os.FileInfo) bool {
-	return info.Name() == "" || info.Name()[0] == '.' || info.Size() == 0 || info.Mode().IsDir() || !info.Mode().IsRegular()
+// isSoundCatalogFile returns true if the file at `pathname` is a catalog that
+// can be read, checksum and all.
+func isSoundCatalogFile(pathname string) bool {
+	f, e := os.Open(pathname)
+	if e != nil {
+		return false
+	}
+	defer f.Close()
+	_, _, e = readCatalog(f)
+	return e == nil
 }
 
-func newCatalog(log *log.Logger, root string) (*Catalog, error) {
-	var c Catalog
-	previousDir := ""
-	e := filepath.Walk(root,
-		func(pathname string, info os.FileInfo, e error) error {
-			if e != nil {
-				log.Print(e)
-				return e
-			}
-			if shouldSkipFile(info) {
-				return nil
-			}
+// writeToFile writes the catalog to a temporary file, syncs it, and then
+// renames it to `pathname`, so that a crash never leaves a partial catalog
+// behind. If `keepBackup` is true, the file previously at `pathname` becomes
+// the backup, unless it is damaged; then the existing backup is kept instead.
+// Only the `catalog` command keeps a backup, so that it is the previous scan's
+// catalog, not the watcher's last save.
+func (c *Catalog) writeToFile(pathname string, keepBackup bool) error {
+	dir, basename := filepath.Split(pathname)
+	if dir == "" {
+		dir = "."
+	}
+	w, e := os.CreateTemp(dir, basename+".*.tmp")
+	if e != nil {
+		return e
+	}
+	temporary := w.Name()
+	fail := func(e error) error {
+		_ = w.Close()
+		_ = os.Remove(temporary)
+		return e
+	}
+	if e := c.write(w); e != nil {
+		return fail(e)
+	}
+	if e := w.Chmod(0644); e != nil {
+		return fail(e)
+	}
+	if e := w.Sync(); e != nil {
+		return fail(e)
+	}
+	if e := w.Close(); e != nil {
+		_ = os.Remove(temporary)
+		return e
+	}
 
-			// If we have progressed to a new directory, print progress indicator.
-			dir := path.Dir(path.Dir(pathname[len(root)+1:]))
-			if dir != previousDir {
-				fmt.Fprintf(os.Stdout, "%s%s", eraseLine, dir)
-				previousDir = dir
-			}
+	if keepBackup && isSoundCatalogFile(pathname) {
+		if e := os.Rename(pathname, getBackupPathname(pathname)); e != nil {
+			_ = os.Remove(temporary)
+			return e
+		}
+	}
+	if e := os.Rename(temporary, pathname); e != nil {
+		_ = os.Remove(temporary)
+		return e
+	}
 
-			if isAudioPathname(pathname) || isVideoPathname(pathname) {
-				webPathname := pathname[len(root)+1:]
-				itemInfo := ItemInfo{Pathname: webPathname}
-
-				input, e := os.Open(pathname)
-				if e != nil {
-					log.Print(e)
-					return e
-				}
-				itemInfo.File, _ = id3.Read(input)
-				if e := input.Close(); e != nil {
-					log.Print(e)
-					return e
-				}
-
-				time := info.ModTime()
-				itemInfo.ModTime = fmt.Sprintf("%04d-%02d-%02d", time.Year(), time.Month(), time.Day())
-				itemInfo.fillMetadata()
-				c.ItemInfos = append(c.ItemInfos, itemInfo)
-			}
-			return nil
-		})
+	// Make the renames durable too. Not all platforms can sync a directory, so
+	// this is best-effort.
+	if d, e := os.Open(dir); e == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
 
-	fmt.Fprintf(os.Stdout, "%s\n", eraseLine)
-	return &c, e
+// readCatalog reads a catalog in any supported version of the file format, and
+// returns it along with the version it was stored as. It does not migrate the
+// catalog.
+func readCatalog(r io.Reader) (*Catalog, int, error) {
+	zr, e := gzip.NewReader(r)
+	if e != nil {
+		return nil, 0, e
+	}
+
+	var magic [len(catalogMagic)]byte
+	n, e := io.ReadFull(zr, magic[:])
+	if e != nil && e != io.ErrUnexpectedEOF && e != io.EOF {
+		return nil, 0, e
+	}
+	version := 0
+	var payload io.Reader = zr
+	if magic == catalogMagic {
+		var v uint32
+		if e := binary.Read(zr, binary.BigEndian, &v); e != nil {
+			return nil, 0, e
+		}
+		if v > catalogVersion {
+			return nil, 0, fmt.Errorf("catalog version %d is newer than this program supports (%d); upgrade bean-machine or run catalog again", v, catalogVersion)
+		}
+		version = int(v)
+	} else {
+		// A version 0 catalog: put back what we took.
+		payload = io.MultiReader(bytes.NewReader(magic[:n]), zr)
+	}
+
+	if version >= 2 {
+		var checksum [sha256.Size]byte
+		if _, e := io.ReadFull(zr, checksum[:]); e != nil {
+			return nil, 0, e
+		}
+		data, e := io.ReadAll(zr)
+		if e != nil {
+			return nil, 0, e
+		}
+		if sha256.Sum256(data) != checksum {
+			return nil, 0, errors.New("catalog checksum does not match")
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	var c Catalog
+	if e := gob.NewDecoder(payload).Decode(&c); e != nil && e != io.EOF {
+		return nil, 0, e
+	}
+	if e := zr.Close(); e != nil {
+		return nil, 0, e
+	}
+	return &c, version, nil
+}
+
+// readCatalogWithFallback reads the catalog at `pathname`, or if that fails,
+// its backup.
+func readCatalogWithFallback(log *log.Logger, pathname string) (*Catalog, error) {
+	c, e := readCatalogFromFile(pathname)
+	if e == nil {
+		return c, nil
+	}
+	backup := getBackupPathname(pathname)
+	c, backupError := readCatalogFromFile(backup)
+	if backupError != nil {
+		return nil, e
+	}
+	log.Printf("%v; using backup %s", e, backup)
+	return c, nil
+}
+
+// migrate upgrades `c`, read from a version `version` file, to the current
+// version.
+func (c *Catalog) migrate(version int) error {
+	for v := version; v < catalogVersion; v++ {
+		if e := catalogMigrations[v](c); e != nil {
+			return fmt.Errorf("migrating catalog from version %d: %v", v, e)
+		}
+	}
+	return nil
 }
 
+// readCatalogFromFile reads the catalog at `pathname`. If it is in an older
+// version of the format, it is migrated to the current version in memory; the
+// file is left as it is. The `catalog` command and the watcher save the
+// migrated catalog when they next write it.
 func readCatalogFromFile(pathname string) (*Catalog, error) {
 	f, e := os.Open(pathname)
 	if e != nil {
 		return nil, e
 	}
-	zr, e := gzip.NewReader(f)
+	c, version, e := readCatalog(f)
 	if e != nil {
-		return nil, e
-	}
-	var c Catalog
-	d := gob.NewDecoder(zr)
-	if e := d.Decode(&c); e != nil && e != io.EOF {
-		return nil, e
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %v", pathname, e)
 	}
-	if e = zr.Close(); e != nil {
+	if e := f.Close(); e != nil {
 		return nil, e
 	}
-	if e = f.Close(); e != nil {
-		return nil, e
+
+	if version < catalogVersion {
+		if e := c.migrate(version); e != nil {
+			return nil, fmt.Errorf("%s: %v", pathname, e)
+		}
 	}
-	return &c, nil
+	return c, nil
 }
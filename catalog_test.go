@@ -0,0 +1,403 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"id3"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, pathname, contents string) {
+	t.Helper()
+	if e := os.MkdirAll(path.Dir(pathname), 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.WriteFile(pathname, []byte(contents), 0644); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestNewCatalogIncremental(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	writeTestFile(t, path.Join(root, "Artist/Album/01 Kept.mp3"), "kept")
+	writeTestFile(t, path.Join(root, "Artist/Album/02 Changed.mp3"), "changed")
+	writeTestFile(t, path.Join(root, "Artist/Album/03 Deleted.mp3"), "deleted")
+
+	previous, _ := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 4})
+	if len(previous.ItemInfos) != 3 {
+		t.Fatalf("expected 3 items, got %d", len(previous.ItemInfos))
+	}
+	// Mark the items so that we can tell whether they were reused.
+	for i := range previous.ItemInfos {
+		previous.ItemInfos[i].Genre = "reused"
+		previous.ItemInfos[i].Added = "2001-02-03"
+	}
+
+	writeTestFile(t, path.Join(root, "Artist/Album/02 Changed.mp3"), "changed again")
+	if e := os.Remove(path.Join(root, "Artist/Album/03 Deleted.mp3")); e != nil {
+		t.Fatal(e)
+	}
+	writeTestFile(t, path.Join(root, "Artist/Album/04 Added.mp3"), "added")
+	later := time.Now().Add(time.Hour)
+	if e := os.Chtimes(path.Join(root, "Artist/Album/02 Changed.mp3"), later, later); e != nil {
+		t.Fatal(e)
+	}
+
+	c, _ := newCatalog(logger, musicRoots{{Pathname: root}}, previous, scanOptions{Workers: 4})
+	today := formatDate(time.Now())
+	expected := map[string][2]string{
+		"Artist/Album/01%20Kept.mp3":    {"reused", "2001-02-03"},
+		"Artist/Album/02%20Changed.mp3": {"", "2001-02-03"},
+		"Artist/Album/04%20Added.mp3":   {"", today},
+	}
+	if len(c.ItemInfos) != len(expected) {
+		t.Fatalf("expected %d items, got %d", len(expected), len(c.ItemInfos))
+	}
+	for _, item := range c.ItemInfos {
+		e, ok := expected[item.Pathname]
+		if !ok {
+			t.Errorf("unexpected item %q", item.Pathname)
+			continue
+		}
+		if item.Genre != e[0] {
+			t.Errorf("%q: expected genre %q, got %q", item.Pathname, e[0], item.Genre)
+		}
+		if item.Added != e[1] {
+			t.Errorf("%q: expected added %q, got %q", item.Pathname, e[1], item.Added)
+		}
+	}
+}
+
+func TestNewCatalogReadsOnlyMissing(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	writeTestFile(t, path.Join(root, "Artist/Album/01 Old.mp3"), "old")
+
+	previous, _ := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 1})
+	if len(previous.ItemInfos) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(previous.ItemInfos))
+	}
+	// Make the item look like it came from a version 2 catalog.
+	item := &previous.ItemInfos[0]
+	item.Genre = "reused"
+	item.ID = ""
+	item.AudioProperties = AudioProperties{}
+	item.MissingProperties = true
+
+	c, report := newCatalog(logger, musicRoots{{Pathname: root}}, previous, scanOptions{Workers: 1})
+	if len(c.ItemInfos) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(c.ItemInfos))
+	}
+	item = &c.ItemInfos[0]
+	if item.Genre != "reused" {
+		t.Errorf("expected the tags to be kept, got genre %q", item.Genre)
+	}
+	if item.ID == "" || item.MissingProperties {
+		t.Errorf("expected the ID and properties to be read, got %+v", item)
+	}
+	if report.Read != 1 {
+		t.Errorf("expected 1 item read, got %+v", report)
+	}
+}
+
+func TestNewCatalogOrderIsDeterministic(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	for i := 0; i < 50; i++ {
+		writeTestFile(t, path.Join(root, fmt.Sprintf("Artist/Album %d/%02d Track.mp3", i%5, i)), "audio")
+	}
+
+	serial, _ := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 1})
+	parallel, _ := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 8})
+	if len(serial.ItemInfos) != 50 || len(parallel.ItemInfos) != 50 {
+		t.Fatalf("expected 50 items, got %d and %d", len(serial.ItemInfos), len(parallel.ItemInfos))
+	}
+	for i := range serial.ItemInfos {
+		if serial.ItemInfos[i].Pathname != parallel.ItemInfos[i].Pathname {
+			t.Errorf("%d: %q != %q", i, serial.ItemInfos[i].Pathname, parallel.ItemInfos[i].Pathname)
+		}
+	}
+}
+
+func TestLiveCatalogReload(t *testing.T) {
+	pathname := path.Join(t.TempDir(), catalogBasename)
+	old := &Catalog{ItemInfos: ItemInfos{{Pathname: "old.mp3"}}}
+	live := newLiveCatalog(old)
+
+	c := &Catalog{ItemInfos: ItemInfos{{Pathname: "new1.mp3"}, {Pathname: "new2.mp3"}}}
+	if e := c.writeToFile(pathname, true); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := live.reload(pathname); e != nil {
+		t.Fatal(e)
+	}
+	if n := len(live.Load().ItemInfos); n != 2 {
+		t.Errorf("expected 2 items after reload, got %d", n)
+	}
+	if len(old.ItemInfos) != 1 || old.ItemInfos[0].Pathname != "old.mp3" {
+		t.Errorf("reload modified the old catalog")
+	}
+
+	if _, e := live.reload(path.Join(t.TempDir(), "missing")); e == nil {
+		t.Error("expected an error reloading a missing catalog")
+	}
+	if n := len(live.Load().ItemInfos); n != 2 {
+		t.Errorf("failed reload replaced the catalog")
+	}
+}
+
+func TestLiveCatalogFindByID(t *testing.T) {
+	live := newLiveCatalog(&Catalog{ItemInfos: ItemInfos{{Pathname: "a.mp3", ID: "a"}, {Pathname: "b.mp3", ID: "b"}}})
+	if item := live.Load().findByID("b"); item == nil || item.Pathname != "b.mp3" {
+		t.Errorf("expected b.mp3, got %v", item)
+	}
+
+	live.update(func(*Catalog) *Catalog {
+		return &Catalog{ItemInfos: ItemInfos{{Pathname: "c.mp3", ID: "c"}, {Pathname: "moved/b.mp3", ID: "b"}}}
+	})
+	c := live.Load()
+	if item := c.findByID("b"); item == nil || item.Pathname != "moved/b.mp3" {
+		t.Errorf("expected moved/b.mp3, got %v", item)
+	}
+	if item := c.findByID("a"); item != nil {
+		t.Errorf("expected no item, got %v", item)
+	}
+	if item := c.findByID(""); item != nil {
+		t.Errorf("expected no item for an empty ID, got %v", item)
+	}
+}
+
+func TestNewCatalogRecordsErrors(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	writeTestFile(t, path.Join(root, "Artist/Album/01 Fine.mp3"), "fine")
+	// An ID3 header with an unsupported version.
+	writeTestFile(t, path.Join(root, "Artist/Album/02 Broken.mp3"), "ID3\x05\x00\x00\x00\x00\x00\x10")
+	writeTestFile(t, path.Join(root, "Artist/Album/03 Fine.mp3"), "fine")
+
+	c, report := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 2})
+	if len(c.ItemInfos) != 3 {
+		t.Errorf("expected 3 items, got %d", len(c.ItemInfos))
+	}
+	if len(report.Errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(report.Errors))
+	}
+	e := report.Errors[0]
+	if e.Stage != scanStageTags || e.Pathname != path.Join(root, "Artist/Album/02 Broken.mp3") {
+		t.Errorf("unexpected error %v", e)
+	}
+	if report.Items != 3 || report.Read != 3 {
+		t.Errorf("unexpected counts %+v", report)
+	}
+}
+
+func TestReadCatalogVersions(t *testing.T) {
+	pathname := path.Join(t.TempDir(), catalogBasename)
+
+	// Write a version 0 catalog, which has no header.
+	f, e := os.Create(pathname)
+	if e != nil {
+		t.Fatal(e)
+	}
+	zw := gzip.NewWriter(f)
+	if e := gob.NewEncoder(zw).Encode(&Catalog{ItemInfos: ItemInfos{{Pathname: "legacy.mp3", ModTime: "2010-01-01"}}}); e != nil {
+		t.Fatal(e)
+	}
+	if e := zw.Close(); e != nil {
+		t.Fatal(e)
+	}
+	if e := f.Close(); e != nil {
+		t.Fatal(e)
+	}
+
+	c, e := readCatalogFromFile(pathname)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(c.ItemInfos) != 1 || c.ItemInfos[0].Pathname != "legacy.mp3" {
+		t.Errorf("unexpected items %v", c.ItemInfos)
+	}
+	if c.ItemInfos[0].Added != "2010-01-01" {
+		t.Errorf("expected migration to set added from mtime, got %q", c.ItemInfos[0].Added)
+	}
+
+	// Reading should not have changed the file.
+	f, e = os.Open(pathname)
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, version, e := readCatalog(f)
+	f.Close()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if version != 0 {
+		t.Errorf("expected the file to stay at version 0, got %d", version)
+	}
+	if _, e := os.Stat(getBackupPathname(pathname)); !os.IsNotExist(e) {
+		t.Errorf("expected no backup after reading, got %v", e)
+	}
+
+	// A catalog from the future should be rejected.
+	var b bytes.Buffer
+	zw = gzip.NewWriter(&b)
+	zw.Write(catalogMagic[:])
+	binary.Write(zw, binary.BigEndian, uint32(catalogVersion+1))
+	zw.Close()
+	if _, _, e := readCatalog(&b); e == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
+
+func TestMigrateLegacyTags(t *testing.T) {
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var payload bytes.Buffer
+	if e := gob.NewEncoder(&payload).Encode(&Catalog{ItemInfos: ItemInfos{
+		{Pathname: "a.mp3", FileModTime: modTime, File: &id3.File{Header: id3.ID3v2Header{Version: 3}, Name: "A"}},
+		{Pathname: "b.flac", FileModTime: modTime, File: &id3.File{Name: "B", AlbumArtist: "Band"}},
+	}}); e != nil {
+		t.Fatal(e)
+	}
+	checksum := sha256.Sum256(payload.Bytes())
+
+	// Write a version 8 catalog, which stores tags in `File`.
+	pathname := path.Join(t.TempDir(), catalogBasename)
+	f, e := os.Create(pathname)
+	if e != nil {
+		t.Fatal(e)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write(catalogMagic[:])
+	binary.Write(zw, binary.BigEndian, uint32(8))
+	zw.Write(checksum[:])
+	zw.Write(payload.Bytes())
+	if e := zw.Close(); e != nil {
+		t.Fatal(e)
+	}
+	if e := f.Close(); e != nil {
+		t.Fatal(e)
+	}
+
+	c, e := readCatalogFromFile(pathname)
+	if e != nil {
+		t.Fatal(e)
+	}
+	a, b := c.ItemInfos[0], c.ItemInfos[1]
+	if a.File != nil || a.Tags == nil || a.Tags.Format != "ID3v2.3" || a.Tags.Name != "A" {
+		t.Errorf("unexpected ID3 item %+v, tags %+v", a, a.Tags)
+	}
+	if b.File != nil || b.Tags == nil || b.Tags.Format != "FLAC" || b.Tags.Name != "B" || b.Tags.AlbumArtist != "Band" {
+		t.Errorf("unexpected FLAC item %+v, tags %+v", b, b.Tags)
+	}
+	// Only ID3 tags need to be read again, for the 9 → 10 migration.
+	if !a.FileModTime.IsZero() || !b.FileModTime.Equal(modTime) {
+		t.Errorf("unexpected modification times %v and %v", a.FileModTime, b.FileModTime)
+	}
+}
+
+func TestCatalogChecksumAndBackup(t *testing.T) {
+	pathname := path.Join(t.TempDir(), catalogBasename)
+	first := &Catalog{ItemInfos: ItemInfos{{Pathname: "first.mp3"}}}
+	second := &Catalog{ItemInfos: ItemInfos{{Pathname: "second.mp3"}}}
+	if e := first.writeToFile(pathname, true); e != nil {
+		t.Fatal(e)
+	}
+	if e := second.writeToFile(pathname, true); e != nil {
+		t.Fatal(e)
+	}
+	// Saves that don't keep a backup, like the watcher's, leave it alone.
+	third := &Catalog{ItemInfos: ItemInfos{{Pathname: "third.mp3"}}}
+	if e := third.writeToFile(pathname, false); e != nil {
+		t.Fatal(e)
+	}
+
+	backup, e := readCatalogFromFile(getBackupPathname(pathname))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if backup.ItemInfos[0].Pathname != "first.mp3" {
+		t.Errorf("expected the backup to hold the first catalog, got %v", backup.ItemInfos)
+	}
+
+	// Replace the current catalog with one whose checksum is wrong.
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write(catalogMagic[:])
+	binary.Write(zw, binary.BigEndian, uint32(catalogVersion))
+	zw.Write(make([]byte, sha256.Size))
+	gob.NewEncoder(zw).Encode(second)
+	zw.Close()
+	if e := os.WriteFile(pathname, b.Bytes(), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := readCatalogFromFile(pathname); e == nil {
+		t.Error("expected a checksum error")
+	}
+
+	c, e := readCatalogWithFallback(log.New(io.Discard, "", 0), pathname)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if c.ItemInfos[0].Pathname != "first.mp3" {
+		t.Errorf("expected to fall back to the backup, got %v", c.ItemInfos)
+	}
+
+	// Writing over a damaged catalog keeps the good backup.
+	if e := third.writeToFile(pathname, true); e != nil {
+		t.Fatal(e)
+	}
+	backup, e = readCatalogFromFile(getBackupPathname(pathname))
+	if e != nil || backup.ItemInfos[0].Pathname != "first.mp3" {
+		t.Errorf("expected the backup to still hold the first catalog, got %v, %v", backup, e)
+	}
+}
+
+func TestNewCatalogSeveralRoots(t *testing.T) {
+	music, books := t.TempDir(), t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	writeTestFile(t, path.Join(music, "Artist/Album/01 Song.mp3"), "song")
+	writeTestFile(t, path.Join(books, "Author/Book/01 Chapter.mp3"), "chapter")
+
+	roots := musicRoots{{Name: "music", Pathname: music}, {Name: "books", Pathname: books}}
+	c, _ := newCatalog(logger, roots, nil, scanOptions{Workers: 2})
+	expected := []string{"music/Artist/Album/01%20Song.mp3", "books/Author/Book/01%20Chapter.mp3"}
+	if len(c.ItemInfos) != len(expected) {
+		t.Fatalf("expected %d items, got %d", len(expected), len(c.ItemInfos))
+	}
+	for i, pathname := range expected {
+		if c.ItemInfos[i].Pathname != pathname {
+			t.Errorf("%d: expected %q, got %q", i, pathname, c.ItemInfos[i].Pathname)
+		}
+		if p := roots.getItemFilePathname(&c.ItemInfos[i]); !strings.HasPrefix(p, roots[i].Pathname+"/") {
+			t.Errorf("%d: %q is not in %q", i, p, roots[i].Pathname)
+		}
+	}
+	if c.ItemInfos[1].Root != "books" || c.ItemInfos[1].Artist != "Author" {
+		t.Errorf("unexpected item %+v", c.ItemInfos[1])
+	}
+}
+
+func TestMigrateMP4ForgetsOnlyMP4(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := &Catalog{ItemInfos: ItemInfos{{Pathname: "a.m4a", FileModTime: modTime}, {Pathname: "b.mp3", FileModTime: modTime}}}
+	if e := catalogMigrations[6](c); e != nil {
+		t.Fatal(e)
+	}
+	if !c.ItemInfos[0].FileModTime.IsZero() || !c.ItemInfos[1].FileModTime.Equal(modTime) {
+		t.Errorf("unexpected modification times %v and %v", c.ItemInfos[0].FileModTime, c.ItemInfos[1].FileModTime)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+type fieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type modifiedItem struct {
+	Pathname string        `json:"pathname"`
+	Changes  []fieldChange `json:"changes"`
+}
+
+// A catalogDiff describes how one catalog differs from another. Items are
+// matched by pathname.
+type catalogDiff struct {
+	Added    ItemInfos      `json:"added"`
+	Removed  ItemInfos      `json:"removed"`
+	Modified []modifiedItem `json:"modified"`
+}
+
+// diffBookkeepingFields are the catalog's own records of when it scanned and
+// first saw an item. They say nothing about the music, and catalog migrations
+// reset them, so they are not compared.
+var diffBookkeepingFields = map[string]bool{
+	"file_mtime": true,
+	"added":      true,
+}
+
+// getItemChanges returns the fields that differ between `a` and `b`. Normalized
+// fields are derived from the others, so they are not compared. Neither are
+// fields that are empty or zero in `a`: catalogs from before IDs, audio
+// properties, and tags were recorded have nothing to compare.
+func getItemChanges(a, b *ItemInfo) []fieldChange {
+	var changes []fieldChange
+	for _, f := range itemFields {
+		if strings.HasPrefix(f.Name, "normalized_") || diffBookkeepingFields[f.Name] {
+			continue
+		}
+		old, new := f.Value(a), f.Value(b)
+		if old == nil || reflect.ValueOf(old).IsZero() {
+			continue
+		}
+		if old != new {
+			changes = append(changes, fieldChange{f.Name, old, new})
+		}
+	}
+	return changes
+}
+
+// diffCatalogs returns the differences between `old` and `new`, in the order of
+// the items in each.
+func diffCatalogs(old, new *Catalog) *catalogDiff {
+	d := catalogDiff{Added: ItemInfos{}, Removed: ItemInfos{}, Modified: []modifiedItem{}}
+	oldItems := old.byPathname()
+	newItems := new.byPathname()
+	for _, item := range old.ItemInfos {
+		if _, ok := newItems[item.Pathname]; !ok {
+			d.Removed = append(d.Removed, item)
+		}
+	}
+	for i := range new.ItemInfos {
+		item := &new.ItemInfos[i]
+		o, ok := oldItems[item.Pathname]
+		if !ok {
+			d.Added = append(d.Added, *item)
+			continue
+		}
+		if changes := getItemChanges(o, item); len(changes) > 0 {
+			d.Modified = append(d.Modified, modifiedItem{item.Pathname, changes})
+		}
+	}
+	return &d
+}
+
+func (d *catalogDiff) writeText(w io.Writer) error {
+	if len(d.Added) > 0 {
+		fmt.Fprintf(w, "Added (%d):\n", len(d.Added))
+		for _, item := range d.Added {
+			fmt.Fprintf(w, "  + %s\n", unescapePathname(item.Pathname))
+		}
+	}
+	if len(d.Removed) > 0 {
+		fmt.Fprintf(w, "Removed (%d):\n", len(d.Removed))
+		for _, item := range d.Removed {
+			fmt.Fprintf(w, "  - %s\n", unescapePathname(item.Pathname))
+		}
+	}
+	if len(d.Modified) > 0 {
+		fmt.Fprintf(w, "Modified (%d):\n", len(d.Modified))
+		for _, m := range d.Modified {
+			fmt.Fprintf(w, "  ~ %s\n", unescapePathname(m.Pathname))
+			for _, c := range m.Changes {
+				fmt.Fprintf(w, "      %s: %q → %q\n", c.Field, formatFieldValue(c.Old), formatFieldValue(c.New))
+			}
+		}
+	}
+	_, e := fmt.Fprintf(w, "%d added, %d removed, %d modified\n", len(d.Added), len(d.Removed), len(d.Modified))
+	return e
+}
+
+func (d *catalogDiff) writeJSON(w io.Writer) error {
+	data, e := json.MarshalIndent(d, "", "  ")
+	if e != nil {
+		return e
+	}
+	_, e = w.Write(append(data, '\n'))
+	return e
+}
+
+// writeCatalogDiff compares the catalogs at `oldPathname` and `newPathname`,
+// and writes the differences to `w` in the given `format`: "text" or "json".
+// Older catalogs are migrated in memory; neither file is changed.
+func writeCatalogDiff(w io.Writer, oldPathname, newPathname, format string) error {
+	old, e := readCatalogFromFile(oldPathname)
+	if e != nil {
+		return e
+	}
+	new, e := readCatalogFromFile(newPathname)
+	if e != nil {
+		return e
+	}
+	d := diffCatalogs(old, new)
+	switch format {
+	case "", "text":
+		return d.writeText(w)
+	case "json":
+		return d.writeJSON(w)
+	}
+	return fmt.Errorf("unknown diff format %q", format)
+}
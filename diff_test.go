@@ -0,0 +1,112 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffCatalogs(t *testing.T) {
+	old := &Catalog{ItemInfos: ItemInfos{
+		{Pathname: "a.mp3", Genre: "Rock"},
+		{Pathname: "b.mp3", Name: "B"},
+		{Pathname: "c.mp3"},
+	}}
+	new := &Catalog{ItemInfos: ItemInfos{
+		{Pathname: "a.mp3", Genre: "Alternative", NormalizedGenre: "alternative"},
+		{Pathname: "b.mp3", Name: "B"},
+		{Pathname: "d.mp3"},
+	}}
+
+	d := diffCatalogs(old, new)
+	if len(d.Added) != 1 || d.Added[0].Pathname != "d.mp3" {
+		t.Errorf("unexpected added %v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Pathname != "c.mp3" {
+		t.Errorf("unexpected removed %v", d.Removed)
+	}
+	if len(d.Modified) != 1 || d.Modified[0].Pathname != "a.mp3" {
+		t.Fatalf("unexpected modified %v", d.Modified)
+	}
+	changes := d.Modified[0].Changes
+	if len(changes) != 1 || changes[0] != (fieldChange{"genre", "Rock", "Alternative"}) {
+		t.Errorf("unexpected changes %v", changes)
+	}
+
+	var b bytes.Buffer
+	if e := d.writeText(&b); e != nil {
+		t.Fatal(e)
+	}
+	if !strings.Contains(b.String(), `genre: "Rock" → "Alternative"`) || !strings.HasSuffix(b.String(), "1 added, 1 removed, 1 modified\n") {
+		t.Errorf("unexpected text %q", b.String())
+	}
+}
+
+func TestWriteCatalogDiffAcrossVersions(t *testing.T) {
+	dir := t.TempDir()
+	oldPathname := path.Join(dir, "old.gobs.gz")
+	newPathname := path.Join(dir, "new.gobs.gz")
+
+	// A version 0 catalog, which predates IDs, `Added`, and the header.
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if e := gob.NewEncoder(zw).Encode(&Catalog{ItemInfos: ItemInfos{{Pathname: "a.mp3", Name: "A", ModTime: "2010-01-01"}}}); e != nil {
+		t.Fatal(e)
+	}
+	if e := zw.Close(); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.WriteFile(oldPathname, b.Bytes(), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	new := &Catalog{ItemInfos: ItemInfos{{Pathname: "a.mp3", Name: "A", ModTime: "2010-01-01", Added: "2020-01-01", FileModTime: time.Now(), ID: "abc"}}}
+	if e := new.writeToFile(newPathname, false); e != nil {
+		t.Fatal(e)
+	}
+
+	var out bytes.Buffer
+	if e := writeCatalogDiff(&out, oldPathname, newPathname, "text"); e != nil {
+		t.Fatal(e)
+	}
+	if out.String() != "0 added, 0 removed, 0 modified\n" {
+		t.Errorf("unexpected text %q", out.String())
+	}
+
+	contents, e := os.ReadFile(oldPathname)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(contents, b.Bytes()) {
+		t.Error("diffing changed the old catalog")
+	}
+	if _, e := os.Stat(getBackupPathname(oldPathname)); !os.IsNotExist(e) {
+		t.Errorf("expected no backup after diffing, got %v", e)
+	}
+}
+
+func TestDiffCatalogsWithoutProperties(t *testing.T) {
+	old := &Catalog{ItemInfos: ItemInfos{{Pathname: "a.mp3", Name: "A"}}}
+	item := ItemInfo{Pathname: "a.mp3", Name: "A", Size: 1234, Tags: &Metadata{Format: "ID3v2.3", Name: "A"}}
+	item.AudioProperties = AudioProperties{Duration: 180, Bitrate: 320000, SampleRate: 44100, Channels: 2}
+	new := &Catalog{ItemInfos: ItemInfos{item}}
+	if d := diffCatalogs(old, new); len(d.Modified) != 0 {
+		t.Errorf("expected no changes, got %+v", d.Modified)
+	}
+
+	// Once there are properties, changes to them count.
+	changed := item
+	changed.Bitrate = 128000
+	d := diffCatalogs(new, &Catalog{ItemInfos: ItemInfos{changed}})
+	if len(d.Modified) != 1 || len(d.Modified[0].Changes) != 1 || d.Modified[0].Changes[0].Field != "bitrate" {
+		t.Errorf("expected a bitrate change, got %+v", d.Modified)
+	}
+}
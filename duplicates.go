@@ -0,0 +1,434 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// Items with the same audio payload: their IDs differ only in the suffix
+	// from `assignUniqueIDs`.
+	duplicateIdentical = "identical"
+	// Items with the same artist, album, disc, and track, but different audio:
+	// usually the same track in different encodings.
+	duplicateSameTrack = "same-track"
+	// Albums that have mostly the same track names.
+	duplicateSimilarAlbum = "similar-album"
+
+	// The fraction of their track names that 2 albums must share to be
+	// `duplicateSimilarAlbum`s.
+	similarAlbumThreshold = 0.8
+)
+
+// A duplicateGroup is some items, or album directories, that are duplicates of
+// each other. `Pathnames` are escaped web pathnames, best first: the first is
+// the one to keep.
+type duplicateGroup struct {
+	Level      string   `json:"level"`
+	Pathnames  []string `json:"pathnames"`
+	Similarity float64  `json:"similarity,omitempty"`
+}
+
+// isBetterItem returns true if `a` should be kept rather than `b`: if it has a
+// higher bitrate or, failing that, is larger.
+func isBetterItem(a, b *ItemInfo) bool {
+	if a.Bitrate != b.Bitrate {
+		return a.Bitrate > b.Bitrate
+	}
+	return a.Size > b.Size
+}
+
+// getItemGroup sorts `items` best first, and returns their pathnames.
+func getItemGroup(items []*ItemInfo) []string {
+	sort.SliceStable(items, func(i, j int) bool { return isBetterItem(items[i], items[j]) })
+	var pathnames []string
+	for _, item := range items {
+		pathnames = append(pathnames, item.Pathname)
+	}
+	return pathnames
+}
+
+// findDuplicateItems returns the groups of items that have the same key, and
+// more than one distinct audio hash (or any, if `distinctIDs` is false). Items
+// with an empty key are left out.
+func findDuplicateItems(c *Catalog, level string, distinctIDs bool, key func(*ItemInfo) string) []duplicateGroup {
+	var keys []string
+	byKey := map[string][]*ItemInfo{}
+	seen := map[string]bool{}
+	for i := range c.ItemInfos {
+		item := &c.ItemInfos[i]
+		k := key(item)
+		hash := getAudioHash(item.ID)
+		if k == "" || (distinctIDs && seen[k+"\x00"+hash]) {
+			continue
+		}
+		seen[k+"\x00"+hash] = true
+		if _, ok := byKey[k]; !ok {
+			keys = append(keys, k)
+		}
+		byKey[k] = append(byKey[k], item)
+	}
+
+	var groups []duplicateGroup
+	for _, k := range keys {
+		if items := byKey[k]; len(items) > 1 {
+			groups = append(groups, duplicateGroup{Level: level, Pathnames: getItemGroup(items)})
+		}
+	}
+	return groups
+}
+
+// getAlbumTrackNames returns the set of normalized names of the tracks of `a`.
+func getAlbumTrackNames(a *Album, items map[string]*ItemInfo) map[string]bool {
+	names := map[string]bool{}
+	for _, pathname := range a.Tracks {
+		if item, ok := items[pathname]; ok && item.NormalizedName != "" {
+			names[item.NormalizedName] = true
+		}
+	}
+	return names
+}
+
+// getAlbumBitrate returns the average bitrate of the tracks of `a`.
+func getAlbumBitrate(a *Album, items map[string]*ItemInfo) int {
+	total, count := 0, 0
+	for _, pathname := range a.Tracks {
+		if item, ok := items[pathname]; ok {
+			total += item.Bitrate
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / count
+}
+
+// findSimilarAlbums returns the pairs of albums that share at least
+// `similarAlbumThreshold` of their track names. Albums of a single track are
+// left out, since they would match any other album with that track.
+func findSimilarAlbums(c *Catalog) []duplicateGroup {
+	items := c.byPathname()
+	names := make([]map[string]bool, len(c.Albums))
+	// Only albums that share a track name need to be compared.
+	byName := map[string][]int{}
+	for i := range c.Albums {
+		names[i] = getAlbumTrackNames(&c.Albums[i], items)
+		if len(names[i]) < 2 {
+			continue
+		}
+		for name := range names[i] {
+			byName[name] = append(byName[name], i)
+		}
+	}
+
+	var groups []duplicateGroup
+	for i := range c.Albums {
+		if len(names[i]) < 2 {
+			continue
+		}
+		compared := map[int]bool{}
+		for name := range names[i] {
+			for _, j := range byName[name] {
+				if j <= i || compared[j] {
+					continue
+				}
+				compared[j] = true
+				shared := 0
+				for n := range names[i] {
+					if names[j][n] {
+						shared++
+					}
+				}
+				larger := len(names[i])
+				if len(names[j]) > larger {
+					larger = len(names[j])
+				}
+				similarity := float64(shared) / float64(larger)
+				if similarity < similarAlbumThreshold {
+					continue
+				}
+				// Keep the album with more of the tracks or, failing that, the better
+				// encoding.
+				a, b := &c.Albums[i], &c.Albums[j]
+				if len(names[j]) > len(names[i]) || (len(names[j]) == len(names[i]) && getAlbumBitrate(b, items) > getAlbumBitrate(a, items)) {
+					a, b = b, a
+				}
+				groups = append(groups, duplicateGroup{duplicateSimilarAlbum, []string{a.Directory, b.Directory}, similarity})
+			}
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return walkOrderLess(groups[i].Pathnames[0], groups[j].Pathnames[0])
+	})
+	return groups
+}
+
+// findDuplicates returns the duplicate items and albums in `c`, at each level
+// of matching.
+func findDuplicates(c *Catalog) []duplicateGroup {
+	groups := findDuplicateItems(c, duplicateIdentical, false, func(i *ItemInfo) string { return getAudioHash(i.ID) })
+	groups = append(groups, findDuplicateItems(c, duplicateSameTrack, true, func(i *ItemInfo) string {
+		if i.NormalizedArtist == "" || i.NormalizedAlbum == "" || i.NormalizedTrack == "" {
+			return ""
+		}
+		return strings.Join([]string{i.NormalizedArtist, i.NormalizedAlbum, i.NormalizedDisc, i.NormalizedTrack}, "\x00")
+	})...)
+	return append(groups, findSimilarAlbums(c)...)
+}
+
+// confirmIdentical returns `groups`, with each `duplicateIdentical` group split
+// by a hash of all of its items' audio, read from the files in `roots`. Item
+// IDs hash only parts of large files (see `readItemID`), so items with the same
+// ID may still differ. Items whose files cannot be read are left out.
+func confirmIdentical(groups []duplicateGroup, roots musicRoots) []duplicateGroup {
+	var confirmed []duplicateGroup
+	for _, g := range groups {
+		if g.Level != duplicateIdentical {
+			confirmed = append(confirmed, g)
+			continue
+		}
+		var hashes []string
+		byHash := map[string][]string{}
+		for _, pathname := range g.Pathnames {
+			hash, e := readFileAudioHash(roots, pathname)
+			if e != nil {
+				continue
+			}
+			if _, ok := byHash[hash]; !ok {
+				hashes = append(hashes, hash)
+			}
+			byHash[hash] = append(byHash[hash], pathname)
+		}
+		for _, hash := range hashes {
+			if pathnames := byHash[hash]; len(pathnames) > 1 {
+				confirmed = append(confirmed, duplicateGroup{Level: g.Level, Pathnames: pathnames})
+			}
+		}
+	}
+	return confirmed
+}
+
+// readFileAudioHash returns the `readAudioHash` of the item at `pathname`, an
+// escaped web pathname in `roots`.
+func readFileAudioHash(roots musicRoots, pathname string) (string, error) {
+	p, ok := roots.resolve(unescapePathname(pathname))
+	if !ok {
+		return "", fmt.Errorf("%s is not in any music root", unescapePathname(pathname))
+	}
+	f, e := os.Open(p)
+	if e != nil {
+		return "", e
+	}
+	defer f.Close()
+	info, e := f.Stat()
+	if e != nil {
+		return "", e
+	}
+	return readAudioHash(p, f, info.Size())
+}
+
+// A removal is an item or album directory to remove, and the one in its group
+// that is kept instead. When an album is removed, only the tracks whose names
+// are also in the kept album go; the rest are `Unique`, and are kept.
+type removal struct {
+	Pathname string   `json:"pathname"`
+	Kept     string   `json:"kept"`
+	Unique   []string `json:"unique,omitempty"`
+}
+
+// planRemovals returns the items and album directories in `c` to remove to get
+// rid of the duplicates in `groups`. In each group, the first pathname that is
+// not already being removed is kept. Albums are considered first, and items
+// removed along with an album are not listed separately.
+func planRemovals(c *Catalog, groups []duplicateGroup) []removal {
+	items := c.byPathname()
+	albums := c.byDirectory()
+	var removals []removal
+	removedAlbums := map[string]bool{}
+	removedItems := map[string]bool{}
+	for _, g := range groups {
+		if g.Level != duplicateSimilarAlbum {
+			continue
+		}
+		kept := ""
+		for _, pathname := range g.Pathnames {
+			if removedAlbums[pathname] {
+				continue
+			}
+			if kept == "" {
+				kept = pathname
+				continue
+			}
+			r := removal{Pathname: pathname, Kept: kept}
+			names := getAlbumTrackNames(albums[kept], items)
+			for _, track := range albums[pathname].Tracks {
+				if item, ok := items[track]; ok && names[item.NormalizedName] {
+					removedItems[track] = true
+				} else {
+					r.Unique = append(r.Unique, track)
+				}
+			}
+			removedAlbums[pathname] = true
+			removals = append(removals, r)
+		}
+	}
+	for _, g := range groups {
+		if g.Level == duplicateSimilarAlbum {
+			continue
+		}
+		kept := ""
+		for _, pathname := range g.Pathnames {
+			if removedItems[pathname] {
+				continue
+			}
+			if kept == "" {
+				kept = pathname
+				continue
+			}
+			removedItems[pathname] = true
+			removals = append(removals, removal{Pathname: pathname, Kept: kept})
+		}
+	}
+	return removals
+}
+
+// quoteShellWord quotes `s` for the POSIX shell.
+func quoteShellWord(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// writeRemovalPlan writes a shell script that carries out `removals`, of items
+// and album directories in `c` and `roots`. It does not remove anything itself.
+// Only the files of an album that it knows about are removed, not its
+// subdirectories or other files, so removing its directory fails unless that
+// leaves it empty. An album with `Unique` tracks keeps them, its cover, its
+// documents, and its directory. If any pathname is not in `roots`, nothing is
+// written.
+func writeRemovalPlan(w io.Writer, c *Catalog, roots musicRoots, removals []removal) error {
+	resolve := func(pathname string) (string, error) {
+		p, ok := roots.resolve(unescapePathname(pathname))
+		if !ok {
+			return "", fmt.Errorf("%s is not in any music root", unescapePathname(pathname))
+		}
+		return quoteShellWord(p), nil
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "#!/bin/sh\n# Removes %d duplicates. Nothing has been removed yet; review this, then run it.\n", len(removals))
+	albums := c.byDirectory()
+	for _, r := range removals {
+		a, ok := albums[r.Pathname]
+		if !ok {
+			p, e := resolve(r.Pathname)
+			if e != nil {
+				return e
+			}
+			fmt.Fprintf(&b, "rm -- %s\n", p)
+			continue
+		}
+
+		unique := map[string]bool{}
+		for _, track := range r.Unique {
+			unique[track] = true
+		}
+		var files []string
+		for _, track := range a.Tracks {
+			if !unique[track] {
+				files = append(files, track)
+			}
+		}
+		if len(r.Unique) == 0 {
+			if a.Cover != "" {
+				files = append(files, a.Cover)
+			}
+			files = append(files, a.Documents...)
+		}
+		for _, f := range files {
+			p, e := resolve(f)
+			if e != nil {
+				return e
+			}
+			fmt.Fprintf(&b, "rm -- %s\n", p)
+		}
+
+		if len(r.Unique) > 0 {
+			kept, e := resolve(r.Kept)
+			if e != nil {
+				return e
+			}
+			fmt.Fprintf(&b, "# Not in %s, so kept:\n", kept)
+			for _, track := range r.Unique {
+				p, e := resolve(track)
+				if e != nil {
+					return e
+				}
+				fmt.Fprintf(&b, "#   %s\n", p)
+			}
+			continue
+		}
+		p, e := resolve(a.Directory)
+		if e != nil {
+			return e
+		}
+		fmt.Fprintf(&b, "rmdir -- %s\n", p)
+	}
+	_, e := w.Write(b.Bytes())
+	return e
+}
+
+func writeDuplicatesText(w io.Writer, c *Catalog, groups []duplicateGroup) error {
+	items := c.byPathname()
+	counts := map[string]int{}
+	for _, g := range groups {
+		if g.Level == duplicateSimilarAlbum {
+			fmt.Fprintf(w, "%s (%.0f%% of tracks)\n", g.Level, 100*g.Similarity)
+		} else {
+			fmt.Fprintf(w, "%s\n", g.Level)
+		}
+		for _, pathname := range g.Pathnames {
+			if item, ok := items[pathname]; ok {
+				fmt.Fprintf(w, "  %s (%d kbps, %d bytes)\n", unescapePathname(pathname), item.Bitrate/1000, item.Size)
+			} else {
+				fmt.Fprintf(w, "  %s/\n", unescapePathname(pathname))
+			}
+		}
+		counts[g.Level]++
+	}
+	_, e := fmt.Fprintf(w, "%d groups of duplicates: %d identical, %d same track, %d similar albums\n", len(groups), counts[duplicateIdentical], counts[duplicateSameTrack], counts[duplicateSimilarAlbum])
+	return e
+}
+
+// writeDuplicates writes the duplicates in `c`, whose files are in `roots`, to
+// `w` in the given `format`: "text", "json", or "plan" (a shell script that
+// removes all but the best of each group). Identical items are confirmed by
+// reading their files; see `confirmIdentical`.
+func writeDuplicates(w io.Writer, c *Catalog, roots musicRoots, format string) error {
+	groups := confirmIdentical(findDuplicates(c), roots)
+	switch format {
+	case "", "text":
+		return writeDuplicatesText(w, c, groups)
+	case "json":
+		report := struct {
+			Groups   []duplicateGroup `json:"groups"`
+			Removals []removal        `json:"removals"`
+		}{append([]duplicateGroup{}, groups...), append([]removal{}, planRemovals(c, groups)...)}
+		data, e := json.MarshalIndent(report, "", "  ")
+		if e != nil {
+			return e
+		}
+		_, e = w.Write(append(data, '\n'))
+		return e
+	case "plan":
+		return writeRemovalPlan(w, c, roots, planRemovals(c, groups))
+	}
+	return fmt.Errorf("unknown duplicates format %q", format)
+}
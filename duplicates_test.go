@@ -0,0 +1,155 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	item := func(pathname, id, name, track string, bitrate int) ItemInfo {
+		i := ItemInfo{Pathname: pathname, ID: id, Artist: "Band", Album: "Album", Name: name, Track: track}
+		i.Bitrate = bitrate
+		i.normalize()
+		return i
+	}
+	c := &Catalog{ItemInfos: ItemInfos{
+		item("Band/Album/01%20One.flac", "a", "One", "1", 900000),
+		item("Band/Album/02%20Two.flac", "b", "Two", "2", 900000),
+		item("Band/Album/03%20Three.flac", "c", "Three", "3", 900000),
+		item("Band/Album%20(MP3)/01%20One.mp3", "d", "One", "1", 320000),
+		item("Band/Album%20(MP3)/02%20Two.mp3", "e", "Two", "2", 320000),
+		item("Band/Album%20(MP3)/03%20Three.mp3", "f", "Three", "3", 320000),
+		item("Band/Album%20(MP3)/03%20Three%20copy.mp3", "f", "Three", "3", 320000),
+		item("Other/Single/01%20One.mp3", "g", "One", "1", 128000),
+	}}
+	c.ItemInfos[7].Album, c.ItemInfos[7].NormalizedAlbum = "Single", "single"
+	c.aggregate(nil, nil)
+
+	groups := findDuplicates(c)
+	var got []string
+	for _, g := range groups {
+		got = append(got, g.Level+": "+strings.Join(g.Pathnames, ", "))
+	}
+	expected := []string{
+		"identical: Band/Album%20(MP3)/03%20Three.mp3, Band/Album%20(MP3)/03%20Three%20copy.mp3",
+		"same-track: Band/Album/01%20One.flac, Band/Album%20(MP3)/01%20One.mp3",
+		"same-track: Band/Album/02%20Two.flac, Band/Album%20(MP3)/02%20Two.mp3",
+		"same-track: Band/Album/03%20Three.flac, Band/Album%20(MP3)/03%20Three.mp3",
+		"similar-album: Band/Album, Band/Album%20(MP3)",
+	}
+	if !stringSlicesEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	// The FLAC album is kept, so the MP3 album goes, and its items need not be
+	// removed separately.
+	removals := planRemovals(c, groups)
+	if len(removals) != 1 || removals[0].Pathname != "Band/Album%20(MP3)" || removals[0].Kept != "Band/Album" || len(removals[0].Unique) != 0 {
+		t.Errorf("unexpected removals %+v", removals)
+	}
+
+	var b bytes.Buffer
+	if e := writeDuplicates(&b, c, musicRoots{{Pathname: "/music"}}, "plan"); e != nil {
+		t.Fatal(e)
+	}
+	for _, line := range []string{"rm -- '/music/Band/Album (MP3)/01 One.mp3'\n", "rm -- '/music/Band/Album (MP3)/03 Three copy.mp3'\n", "rmdir -- '/music/Band/Album (MP3)'\n"} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("expected %q in the plan, got %q", line, b.String())
+		}
+	}
+	if e := writeDuplicates(&b, c, nil, "yaml"); e == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestQuoteShellWord(t *testing.T) {
+	if got := quoteShellWord("Don't Stop"); got != `'Don'\''t Stop'` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestPlanRemovalsKeepsUniqueTracks(t *testing.T) {
+	item := func(pathname, id, album, name string, bitrate int) ItemInfo {
+		i := ItemInfo{Pathname: pathname, ID: id, Artist: "Band", Album: album, Name: name}
+		i.Bitrate = bitrate
+		i.normalize()
+		return i
+	}
+	c := &Catalog{ItemInfos: ItemInfos{
+		item("Band/A/1.flac", "a1", "A", "One", 900000),
+		item("Band/A/2.flac", "a2", "A", "Two", 900000),
+		item("Band/A/3.flac", "a3", "A", "Three", 900000),
+		item("Band/A/4.flac", "a4", "A", "Four", 900000),
+		item("Band/A/5.flac", "a5", "A", "Five", 900000),
+		item("Band/B/1.mp3", "b1", "B", "One", 128000),
+		item("Band/B/2.mp3", "b2", "B", "Two", 128000),
+		item("Band/B/3.mp3", "b3", "B", "Three", 128000),
+		item("Band/B/4.mp3", "b4", "B", "Four", 128000),
+		item("Band/B/5.mp3", "b5", "B", "Bonus", 128000),
+	}}
+	c.aggregate(nil, nil)
+
+	removals := planRemovals(c, findDuplicates(c))
+	if len(removals) != 1 || removals[0].Pathname != "Band/B" || !stringSlicesEqual(removals[0].Unique, []string{"Band/B/5.mp3"}) {
+		t.Fatalf("unexpected removals %+v", removals)
+	}
+
+	var b bytes.Buffer
+	if e := writeRemovalPlan(&b, c, musicRoots{{Pathname: "/music"}}, removals); e != nil {
+		t.Fatal(e)
+	}
+	if !strings.Contains(b.String(), "rm -- '/music/Band/B/4.mp3'\n") || !strings.Contains(b.String(), "#   '/music/Band/B/5.mp3'\n") {
+		t.Errorf("unexpected plan %q", b.String())
+	}
+	if strings.Contains(b.String(), "rm -- '/music/Band/B/5.mp3'") || strings.Contains(b.String(), "rmdir") {
+		t.Errorf("plan removes a unique track or its directory: %q", b.String())
+	}
+
+	// A pathname outside the roots is an error, and no plan is written.
+	b.Reset()
+	if e := writeRemovalPlan(&b, c, musicRoots{{Name: "other", Pathname: "/other"}}, removals); e == nil || b.Len() != 0 {
+		t.Errorf("expected an error and no plan, got %v and %q", e, b.String())
+	}
+}
+
+func TestConfirmIdentical(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	// The files have the same length and the same parts that `readItemID`
+	// hashes, but differ in between.
+	audio := make([]byte, 1024*1024)
+	for i := range audio {
+		audio[i] = byte(i % 251)
+	}
+	writeTestFile(t, path.Join(root, "Band/Album/01 One.mp3"), string(audio))
+	writeTestFile(t, path.Join(root, "Band/Album/01 One copy.mp3"), string(audio))
+	audio[200*1024] ^= 0xff
+	writeTestFile(t, path.Join(root, "Band/Album/01 One edit.mp3"), string(audio))
+
+	roots := musicRoots{{Pathname: root}}
+	c, _ := newCatalog(logger, roots, nil, scanOptions{Workers: 1})
+	groups := findDuplicates(c)
+	if len(groups) != 1 || len(groups[0].Pathnames) != 3 {
+		t.Fatalf("expected the IDs to match, got %+v", groups)
+	}
+
+	groups = confirmIdentical(groups, roots)
+	if len(groups) != 1 || !stringSlicesEqual(groups[0].Pathnames, []string{"Band/Album/01%20One%20copy.mp3", "Band/Album/01%20One.mp3"}) {
+		t.Errorf("expected only the true copies, got %+v", groups)
+	}
+
+	var b bytes.Buffer
+	if e := writeDuplicates(&b, c, roots, "plan"); e != nil {
+		t.Fatal(e)
+	}
+	if strings.Contains(b.String(), "edit") || !strings.Contains(b.String(), "rm -- ") {
+		t.Errorf("unexpected plan %q", b.String())
+	}
+}
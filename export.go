@@ -0,0 +1,170 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// An itemField is a named, exportable property of an `ItemInfo`. `Value`
+// returns nil if the item doesn't have the property.
+type itemField struct {
+	Name  string
+	Value func(*ItemInfo) interface{}
+}
+
+// tagField makes an `itemField` for a field of `ItemInfo.Tags`, which may be
+// nil.
+func tagField(name string, value func(*Metadata) interface{}) itemField {
+	return itemField{"tags." + name, func(i *ItemInfo) interface{} {
+		if i.Tags == nil {
+			return nil
+		}
+		return value(i.Tags)
+	}}
+}
+
+// itemFields lists every field of `ItemInfo`, in export order.
+var itemFields = []itemField{
+	{"pathname", func(i *ItemInfo) interface{} { return i.Pathname }},
+	{"root", func(i *ItemInfo) interface{} { return i.Root }},
+	{"album", func(i *ItemInfo) interface{} { return i.Album }},
+	{"artist", func(i *ItemInfo) interface{} { return i.Artist }},
+	{"name", func(i *ItemInfo) interface{} { return i.Name }},
+	{"disc", func(i *ItemInfo) interface{} { return i.Disc }},
+	{"track", func(i *ItemInfo) interface{} { return i.Track }},
+	{"year", func(i *ItemInfo) interface{} { return i.Year }},
+	{"genre", func(i *ItemInfo) interface{} { return i.Genre }},
+	{"mtime", func(i *ItemInfo) interface{} { return i.ModTime }},
+	{"file_mtime", func(i *ItemInfo) interface{} { return i.FileModTime.Format(time.RFC3339Nano) }},
+	{"added", func(i *ItemInfo) interface{} { return i.Added }},
+	{"id", func(i *ItemInfo) interface{} { return i.ID }},
+	{"size", func(i *ItemInfo) interface{} { return i.Size }},
+	{"duration", func(i *ItemInfo) interface{} { return i.Duration }},
+	{"bitrate", func(i *ItemInfo) interface{} { return i.Bitrate }},
+	{"samplerate", func(i *ItemInfo) interface{} { return i.SampleRate }},
+	{"channels", func(i *ItemInfo) interface{} { return i.Channels }},
+	{"normalized_pathname", func(i *ItemInfo) interface{} { return i.NormalizedPathname }},
+	{"normalized_album", func(i *ItemInfo) interface{} { return i.NormalizedAlbum }},
+	{"normalized_artist", func(i *ItemInfo) interface{} { return i.NormalizedArtist }},
+	{"normalized_name", func(i *ItemInfo) interface{} { return i.NormalizedName }},
+	{"normalized_disc", func(i *ItemInfo) interface{} { return i.NormalizedDisc }},
+	{"normalized_track", func(i *ItemInfo) interface{} { return i.NormalizedTrack }},
+	{"normalized_year", func(i *ItemInfo) interface{} { return i.NormalizedYear }},
+	{"normalized_genre", func(i *ItemInfo) interface{} { return i.NormalizedGenre }},
+	tagField("format", func(f *Metadata) interface{} { return f.Format }),
+	tagField("name", func(f *Metadata) interface{} { return f.Name }),
+	tagField("artist", func(f *Metadata) interface{} { return f.Artist }),
+	tagField("album", func(f *Metadata) interface{} { return f.Album }),
+	tagField("year", func(f *Metadata) interface{} { return f.Year }),
+	tagField("track", func(f *Metadata) interface{} { return f.Track }),
+	tagField("disc", func(f *Metadata) interface{} { return f.Disc }),
+	tagField("genre", func(f *Metadata) interface{} { return f.Genre }),
+	tagField("length", func(f *Metadata) interface{} { return f.Length }),
+	tagField("album_artist", func(f *Metadata) interface{} { return f.AlbumArtist }),
+	tagField("compilation", func(f *Metadata) interface{} { return f.Compilation }),
+}
+
+// formatFieldValue returns `v` as a string, for formats that have no types.
+func formatFieldValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// exportJSON writes `items` as an array of objects, with keys in the order
+// of `itemFields`.
+func exportJSON(w io.Writer, items ItemInfos) error {
+	b := bufio.NewWriter(w)
+	b.WriteString("[")
+	for n := range items {
+		if n > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  {")
+		for i, f := range itemFields {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			key, e := json.Marshal(f.Name)
+			if e != nil {
+				return e
+			}
+			value, e := json.Marshal(f.Value(&items[n]))
+			if e != nil {
+				return e
+			}
+			b.Write(key)
+			b.WriteString(": ")
+			b.Write(value)
+		}
+		b.WriteString("}")
+	}
+	b.WriteString("\n]\n")
+	return b.Flush()
+}
+
+func exportCSV(w io.Writer, items ItemInfos) error {
+	cw := csv.NewWriter(w)
+	record := make([]string, len(itemFields))
+	for i, f := range itemFields {
+		record[i] = f.Name
+	}
+	if e := cw.Write(record); e != nil {
+		return e
+	}
+	for n := range items {
+		for i, f := range itemFields {
+			record[i] = formatFieldValue(f.Value(&items[n]))
+		}
+		if e := cw.Write(record); e != nil {
+			return e
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportM3U8 writes `items` as an extended M3U playlist of file system
+// pathnames.
+func exportM3U8(w io.Writer, roots musicRoots, items ItemInfos) error {
+	b := bufio.NewWriter(w)
+	b.WriteString("#EXTM3U\n")
+	for n := range items {
+		i := &items[n]
+		duration := -1
+		if i.Duration > 0 {
+			duration = int(math.Round(i.Duration))
+		}
+		fmt.Fprintf(b, "#EXTINF:%d,%s - %s\n", duration, i.Artist, i.Name)
+		b.WriteString(roots.getItemFilePathname(i))
+		b.WriteString("\n")
+	}
+	return b.Flush()
+}
+
+// exportCatalog writes the items in `c` that match `query` (or all of them, if
+// `query` is empty) to `w` in the given `format`: "json", "csv", or "m3u8".
+func exportCatalog(w io.Writer, c *Catalog, roots musicRoots, format, query string) error {
+	items := c.ItemInfos
+	if query != "" {
+		items = matchItems(items, query)
+	}
+	switch format {
+	case "", "json":
+		return exportJSON(w, items)
+	case "csv":
+		return exportCSV(w, items)
+	case "m3u", "m3u8":
+		return exportM3U8(w, roots, items)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
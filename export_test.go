@@ -0,0 +1,75 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func getExportTestCatalog() *Catalog {
+	c := &Catalog{ItemInfos: ItemInfos{
+		{Pathname: "AC_DC/Back In Black/1-01 Hells Bells.m4a"},
+		{Pathname: "Radiohead/Kid A/01 Everything In Its Right Place.mp3", Tags: &Metadata{Name: "Everything In Its Right Place", Year: "2000"}},
+	}}
+	for i := range c.ItemInfos {
+		c.ItemInfos[i].fillMetadata()
+	}
+	return c
+}
+
+func TestExportJSON(t *testing.T) {
+	var b bytes.Buffer
+	if e := exportCatalog(&b, getExportTestCatalog(), musicRoots{{Pathname: "/music"}}, "json", "radiohead"); e != nil {
+		t.Fatal(e)
+	}
+	var items []map[string]interface{}
+	if e := json.Unmarshal(b.Bytes(), &items); e != nil {
+		t.Fatal(e)
+	}
+	if len(items) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(items))
+	}
+	if items[0]["tags.year"] != "2000" || items[0]["artist"] != "Radiohead" {
+		t.Errorf("unexpected item %v", items[0])
+	}
+	if len(items[0]) != len(itemFields) {
+		t.Errorf("expected %d fields, got %d", len(itemFields), len(items[0]))
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	var b bytes.Buffer
+	if e := exportCatalog(&b, getExportTestCatalog(), musicRoots{{Pathname: "/music"}}, "csv", ""); e != nil {
+		t.Fatal(e)
+	}
+	records, e := csv.NewReader(&b).ReadAll()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	if records[0][0] != "pathname" || records[1][3] != "AC_DC" || records[1][4] != "Hells Bells" {
+		t.Errorf("unexpected records %v", records[:2])
+	}
+}
+
+func TestExportM3U8(t *testing.T) {
+	var b bytes.Buffer
+	if e := exportCatalog(&b, getExportTestCatalog(), musicRoots{{Pathname: "/music"}}, "m3u8", "hells"); e != nil {
+		t.Fatal(e)
+	}
+	expected := "#EXTM3U\n#EXTINF:-1,AC_DC - Hells Bells\n/music/AC_DC/Back In Black/1-01 Hells Bells.m4a\n"
+	if b.String() != expected {
+		t.Errorf("expected %q, got %q", expected, b.String())
+	}
+
+	if e := exportCatalog(&b, getExportTestCatalog(), musicRoots{{Pathname: "/music"}}, "xml", ""); e == nil || !strings.Contains(e.Error(), "xml") {
+		t.Errorf("expected an error for an unknown format, got %v", e)
+	}
+}
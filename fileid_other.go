@@ -0,0 +1,25 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// A fileID identifies a file, regardless of the pathname it was reached by.
+// Without device and inode numbers, the best we can do is its real pathname.
+type fileID struct {
+	pathname string
+}
+
+func getFileID(pathname string, info os.FileInfo) (fileID, bool) {
+	real, e := filepath.EvalSymlinks(pathname)
+	if e != nil {
+		return fileID{}, false
+	}
+	return fileID{real}, true
+}
@@ -0,0 +1,25 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// A fileID identifies a file, regardless of the pathname it was reached by.
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+func getFileID(pathname string, info os.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{uint64(stat.Dev), uint64(stat.Ino)}, true
+}
@@ -41,9 +41,10 @@ var (
 )
 
 type httpHandler struct {
-	Root                  string
+	Roots                 musicRoots
 	ConfigurationPathname string
-	*Catalog
+	CatalogPathname       string
+	Catalog               *liveCatalog
 	*log.Logger
 }
 
@@ -83,10 +84,21 @@ func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path == "/search" {
 		h.handleSearch(w, r)
 		return
+	} else if r.URL.Path == "/admin/reload" {
+		h.handleReload(w, r)
+		return
+	} else if r.URL.Path == "/albums" || strings.HasPrefix(r.URL.Path, "/albums/") {
+		h.handleAlbums(w, r)
+		return
+	} else if r.URL.Path == "/artists" || strings.HasPrefix(r.URL.Path, "/artists/") {
+		h.handleArtists(w, r)
+		return
+	} else if strings.HasPrefix(r.URL.Path, "/track/") {
+		h.serveTrack(w, r)
+		return
 	} else if strings.HasSuffix(r.URL.Path, "/media.html") {
-		pathname := path.Clean(h.Root + path.Dir(r.URL.Path))
-		// In addition to `path.Clean`, handle this special case:
-		if pathname == h.Root {
+		pathname, ok := h.Roots.resolve(path.Dir(r.URL.Path))
+		if !ok || h.Roots.isRoot(pathname) || !h.Roots.allows(pathname) {
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
@@ -143,16 +155,15 @@ func (h *httpHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	query := strings.TrimSpace(queries[0])
-	var matches ItemInfos
+	catalog := h.Catalog.Load()
+	matches := ItemInfos{}
 	if len(query) == 0 {
 		year, month, _ := time.Now().Date()
+		thisMonth := time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
 		for i := 0; i < 6; i++ {
-			if i > 0 && month == 1 {
-				month = 12
-				year -= 1
-			}
-			query = fmt.Sprintf("mtime:%04d-%02d-", year, int(month)-i)
-			matches = matchItems(h.Catalog.ItemInfos, query)
+			m := thisMonth.AddDate(0, -i, 0)
+			query = fmt.Sprintf("added:%04d-%02d-", m.Year(), int(m.Month()))
+			matches = matchItems(catalog.ItemInfos, query)
 			if len(matches) > 0 {
 				goto done
 			}
@@ -164,15 +175,22 @@ func (h *httpHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if query == "?" {
-		item := h.Catalog.ItemInfos[rand.Intn(len(h.Catalog.ItemInfos))]
+		if len(catalog.ItemInfos) == 0 {
+			goto done
+		}
+		item := catalog.ItemInfos[rand.Intn(len(catalog.ItemInfos))]
 		words := wordSplitter.Split(path.Dir(item.Pathname), -1)
 		query = words[len(words)-1]
 	}
 
-	matches = matchItems(h.Catalog.ItemInfos, query)
+	matches = matchItems(catalog.ItemInfos, query)
 
 done:
-	json, e := json.Marshal(matches)
+	h.writeJSON(w, matches)
+}
+
+func (h *httpHandler) writeJSON(w http.ResponseWriter, v interface{}) {
+	json, e := json.Marshal(v)
 	if e != nil {
 		h.Logger.Print(e)
 		http.Error(w, "", 500)
@@ -182,15 +200,101 @@ done:
 	}
 }
 
+// handleAlbums serves /albums, the list of albums (only those with items that
+// match the query, if there is a q parameter), and /albums/{directory}, one
+// album along with its items.
+func (h *httpHandler) handleAlbums(w http.ResponseWriter, r *http.Request) {
+	catalog := h.Catalog.Load()
+	if dir := strings.Trim(strings.TrimPrefix(r.URL.Path, "/albums"), "/"); dir != "" {
+		album := catalog.findAlbum(pathnameEscape(dir))
+		if album == nil {
+			http.NotFound(w, r)
+			return
+		}
+		items := catalog.byPathname()
+		tracks := ItemInfos{}
+		for _, pathname := range album.Tracks {
+			tracks = append(tracks, *items[pathname])
+		}
+		h.writeJSON(w, struct {
+			*Album
+			Items ItemInfos `json:"items"`
+		}{album, tracks})
+		return
+	}
+
+	albums := []Album{}
+	if query := strings.TrimSpace(r.URL.Query().Get("q")); query != "" {
+		dirs, _ := groupItemsByDirectory(matchItems(catalog.ItemInfos, query))
+		byDir := catalog.byDirectory()
+		for _, dir := range dirs {
+			albums = append(albums, *byDir[dir])
+		}
+	} else {
+		albums = append(albums, catalog.Albums...)
+	}
+	h.writeJSON(w, albums)
+}
+
+// handleArtists serves /artists, the list of artists, and /artists/{name}, one
+// artist along with their albums.
+func (h *httpHandler) handleArtists(w http.ResponseWriter, r *http.Request) {
+	catalog := h.Catalog.Load()
+	// An empty name, as in `/artists/`, means the list.
+	if name := strings.TrimPrefix(r.URL.Path, "/artists/"); name != r.URL.Path && name != "" {
+		artist := catalog.findArtist(name)
+		if artist == nil {
+			http.NotFound(w, r)
+			return
+		}
+		byDir := catalog.byDirectory()
+		albums := []*Album{}
+		for _, dir := range artist.Albums {
+			albums = append(albums, byDir[dir])
+		}
+		h.writeJSON(w, struct {
+			*Artist
+			Albums []*Album `json:"albums"`
+		}{artist, albums})
+		return
+	}
+	artists := append([]Artist{}, catalog.Artists...)
+	h.writeJSON(w, artists)
+}
+
+// handleReload re-reads the catalog file and swaps it in for the live catalog.
+// Requests already in progress finish with the catalog they started with.
+// Every logged-in user may reload; there is no separate admin credential.
+func (h *httpHandler) handleReload(w http.ResponseWriter, r *http.Request) {
+	if !h.isAuthenticated(r) {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c, e := h.Catalog.reload(h.CatalogPathname)
+	if e != nil {
+		h.Logger.Print(e)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	h.Logger.Printf("Reloaded catalog with %d items", len(c.ItemInfos))
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Reloaded catalog with %d items\n", len(c.ItemInfos))
+}
+
 func (h *httpHandler) normalizePathname(pathname string) string {
 	if pathname == "/" {
 		pathname = "/index.html"
 	}
-	pathname = path.Join(h.Root, filepath.Clean(pathname))
-	if !strings.HasPrefix(pathname, h.Root) {
-		return h.Root + "/404.html"
+	if pathname, ok := h.Roots.resolve(pathname); ok {
+		return pathname
 	}
-	return pathname
+	return ""
 }
 
 func (h *httpHandler) serveContent(w http.ResponseWriter, r *http.Request, pathname string, modified time.Time, content io.ReadSeeker) {
@@ -209,6 +313,9 @@ func (h *httpHandler) serveCover(pathname string, w http.ResponseWriter, r *http
 		}
 		return
 	}
+	if h.serveEmbeddedCover(w, r) {
+		return
+	}
 
 	f, info, e := openFileAndInfoFS("web/unknown-album.png", frontend)
 	if e != nil {
@@ -225,7 +332,47 @@ func (h *httpHandler) serveCover(pathname string, w http.ResponseWriter, r *http
 	}
 }
 
-func zipDirectory(log *log.Logger, pathname string) (*os.File, error) {
+// serveEmbeddedCover serves the cover picture embedded in the tags of the first
+// item in the album at /{directory}/cover that has one. It returns false if
+// there is no such item.
+func (h *httpHandler) serveEmbeddedCover(w http.ResponseWriter, r *http.Request) bool {
+	catalog := h.Catalog.Load()
+	album := catalog.findAlbum(pathnameEscape(strings.Trim(path.Dir(r.URL.Path), "/")))
+	if album == nil {
+		return false
+	}
+	items := catalog.byPathname()
+	for _, pathname := range album.Tracks {
+		item, ok := items[pathname]
+		if !ok {
+			continue
+		}
+		file, info, e := h.openFileIfPublic(h.Roots.getItemFilePathname(item))
+		if e != nil {
+			continue
+		}
+		picture, e := readCoverPicture(info.Name(), file, info.Size())
+		if e != nil {
+			h.Logger.Print(e)
+		}
+		if e := file.Close(); e != nil {
+			h.Logger.Print(e)
+		}
+		if picture == nil {
+			continue
+		}
+		if picture.MIMEType != "" {
+			w.Header().Set("Content-Type", picture.MIMEType)
+		}
+		h.serveContent(w, r, r.URL.Path, info.ModTime(), bytes.NewReader(picture.Data))
+		return true
+	}
+	return false
+}
+
+// zipDirectory returns a temporary file containing a zip of the files in the
+// directory at `pathname`. Entries that lead outside `roots` are left out.
+func zipDirectory(log *log.Logger, roots musicRoots, pathname string) (*os.File, error) {
 	file, e := os.CreateTemp("", "*album.zip")
 	if e != nil {
 		return nil, e
@@ -244,6 +391,9 @@ func zipDirectory(log *log.Logger, pathname string) (*os.File, error) {
 
 	zipWriter := zip.NewWriter(file)
 	for _, entry := range entries {
+		if !roots.allows(pathname + "/" + entry.Name()) {
+			continue
+		}
 		f, e := zipWriter.Create(entry.Name())
 		if e != nil {
 			return nil, e
@@ -266,13 +416,17 @@ func zipDirectory(log *log.Logger, pathname string) (*os.File, error) {
 
 func (h *httpHandler) serveZip(w http.ResponseWriter, r *http.Request) {
 	pathname := h.normalizePathname(r.URL.Path)
+	if !h.Roots.allows(pathname) {
+		h.Logger.Printf("serveZip: %q is outside the music directories", pathname)
+		return
+	}
 	info, e := os.Stat(pathname)
 	if e != nil {
 		h.Logger.Print("stat", e)
 		return
 	}
 
-	zipFile, e := zipDirectory(h.Logger, pathname)
+	zipFile, e := zipDirectory(h.Logger, h.Roots, pathname)
 	if e != nil {
 		h.Logger.Print(e)
 		return
@@ -304,6 +458,18 @@ func (h *httpHandler) serveFile(w http.ResponseWriter, r *http.Request) {
 	h.serveFileContents(pathname, w, r)
 }
 
+// serveTrack serves the file of the item whose ID is in the URL path,
+// /track/{id}. Unlike the item's pathname, its ID stays the same when the file
+// is renamed or moved.
+func (h *httpHandler) serveTrack(w http.ResponseWriter, r *http.Request) {
+	item := h.Catalog.Load().findByID(strings.TrimPrefix(r.URL.Path, "/track/"))
+	if item == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.serveFileContents(h.Roots.getItemFilePathname(item), w, r)
+}
+
 func (h *httpHandler) serveFileContents(pathname string, w http.ResponseWriter, r *http.Request) {
 	file, info, e := h.openFileIfPublic(pathname)
 	if e != nil || file == nil || info == nil {
@@ -317,7 +483,12 @@ func (h *httpHandler) serveFileContents(pathname string, w http.ResponseWriter,
 	}
 }
 
+// openFileIfPublic opens the file at `pathname` if it is world-readable and,
+// following any symbolic links, inside the music directories.
 func (h *httpHandler) openFileIfPublic(pathname string) (*os.File, os.FileInfo, error) {
+	if !h.Roots.allows(pathname) {
+		return nil, nil, fmt.Errorf("openFileIfPublic: %q not in the music directories", pathname)
+	}
 	file, info, e := openFileAndInfo(pathname)
 	if e != nil {
 		return nil, nil, e
@@ -0,0 +1,56 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestHandleReload(t *testing.T) {
+	configuration := t.TempDir()
+	sessions := path.Join(configuration, sessionsDirectoryName)
+	if e := os.MkdirAll(sessions, 0755); e != nil {
+		t.Fatal(e)
+	}
+	token, e := createToken(sessions)
+	if e != nil {
+		t.Fatal(e)
+	}
+	catalogPathname := path.Join(configuration, catalogBasename)
+	c := &Catalog{ItemInfos: ItemInfos{{Pathname: "a.mp3"}}}
+	if e := c.writeToFile(catalogPathname, false); e != nil {
+		t.Fatal(e)
+	}
+	h := &httpHandler{ConfigurationPathname: configuration, CatalogPathname: catalogPathname, Catalog: newLiveCatalog(&Catalog{}), Logger: log.New(io.Discard, "", 0)}
+
+	for _, test := range []struct {
+		method string
+		token  string
+		status int
+	}{
+		{http.MethodPost, "", http.StatusForbidden},
+		{http.MethodPost, "bogus", http.StatusForbidden},
+		{http.MethodGet, token, http.StatusMethodNotAllowed},
+		{http.MethodPost, token, http.StatusOK},
+	} {
+		r := httptest.NewRequest(test.method, "/admin/reload", nil)
+		if test.token != "" {
+			r.AddCookie(getCookie(test.token))
+		}
+		w := httptest.NewRecorder()
+		h.handleReload(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s with token %q: expected status %d, got %d", test.method, test.token, test.status, w.Code)
+		}
+	}
+	if len(h.Catalog.Load().ItemInfos) != 1 {
+		t.Errorf("expected the reloaded catalog, got %+v", h.Catalog.Load())
+	}
+}
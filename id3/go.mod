@@ -1,3 +1,3 @@
 module noncombatant.org/id3
 
-go 1.14
+go 1.18
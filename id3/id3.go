@@ -21,6 +21,7 @@ package id3
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -50,11 +51,42 @@ type File struct {
 	Disc   string
 	Genre  string
 	Length string
+
+	AlbumArtist string
+	Compilation bool
 }
 
-// Parse the input for ID3 information. Returns nil if parsing failed or the
-// input didn't contain ID3 information.
+// ErrNoTag means that the input has no ID3 tag at all, as opposed to a
+// malformed one.
+var ErrNoTag = errors.New("Not an ID3 tag")
+
+// Parse the input for ID3 information. Returns nil and an error if parsing
+// failed, or ErrNoTag if the input didn't contain ID3 information.
+//
+// If the input is an io.ReadSeeker, an ID3v1 tag at the end (and an Enhanced
+// "TAG+" tag before that) is read too. Fields that the ID3v2 tag has take
+// precedence; the others are filled in from the ID3v1 tag. If the ID3v2 tag is
+// malformed, the ID3v1 tag is returned along with the error, and it is up to
+// the caller whether to use it.
 func Read(reader io.Reader) (*File, error) {
+	var v1 *File
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		v1, _ = readID3v1(seeker)
+	}
+	file, err := readID3v2(reader)
+	if v1 == nil {
+		return file, err
+	}
+	if err == ErrNoTag {
+		return v1, nil
+	} else if err != nil {
+		return v1, err
+	}
+	file.merge(v1)
+	return file, nil
+}
+
+func readID3v2(reader io.Reader) (*File, error) {
 	file := new(File)
 	bufReader := bufio.NewReader(reader)
 	err := isID3Tag(bufReader)
@@ -62,16 +94,34 @@ func Read(reader io.Reader) (*File, error) {
 		return nil, err
 	}
 
-	parseID3v2Header(bufReader, file)
+	if err := parseID3v2Header(bufReader, file); err != nil {
+		return nil, err
+	}
 	limitReader := bufio.NewReader(io.LimitReader(bufReader, int64(file.Header.Size)))
-	if file.Header.Version == 2 {
-		parseID3v22File(limitReader, file)
+	if file.Header.Unsynchronization && file.Header.Version < 4 {
+		// Before v2.4, the whole tag, but not its header, is unsynchronised at
+		// once. (In v2.4, each frame is.)
+		data, err := readBytes(limitReader, int(file.Header.Size))
+		if err != nil {
+			return nil, err
+		}
+		limitReader = bufio.NewReader(bytes.NewReader(removeUnsynchronization(data)))
+	}
+	if file.Header.Version == 2 && file.Header.Extended {
+		// In v2.2, this flag means that the tag is compressed, but no compression
+		// scheme was ever defined.
+		err = errors.New("Compressed ID3v2.2 tags are not supported")
+	} else if file.Header.Version == 2 {
+		err = parseID3v22File(limitReader, file)
 	} else if file.Header.Version == 3 {
-		parseID3v23File(limitReader, file)
+		err = parseID3v23File(limitReader, file)
 	} else if file.Header.Version == 4 {
-		parseID3v24File(limitReader, file)
+		err = parseID3v24File(limitReader, file)
 	} else {
-		panic(fmt.Sprintf("Unrecognized ID3v2 version: %d", file.Header.Version))
+		err = fmt.Errorf("Unrecognized ID3v2 version: %d", file.Header.Version)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	return file, nil
@@ -79,25 +129,26 @@ func Read(reader io.Reader) (*File, error) {
 
 func isID3Tag(reader *bufio.Reader) error {
 	data, err := reader.Peek(3)
-	if err != nil {
+	if err != nil && err != io.EOF {
 		return err
 	}
-	if len(data) < 3 {
-		return errors.New("Insufficient data for an ID3 tag")
-	}
-	if data[0] == 'I' && data[1] == 'D' && data[2] == '3' {
-		return nil
+	if len(data) < 3 || data[0] != 'I' || data[1] != 'D' || data[2] != '3' {
+		return ErrNoTag
 	}
-	return errors.New("Not an ID3 tag")
+	return nil
 }
 
-func parseID3v2Header(reader *bufio.Reader, file *File) {
-	data := readBytes(reader, 10)
+func parseID3v2Header(reader *bufio.Reader, file *File) error {
+	data, err := readBytes(reader, 10)
+	if err != nil {
+		return err
+	}
 	file.Header.Version = int(data[3])
 	file.Header.MinorVersion = int(data[4])
-	file.Header.Unsynchronization = data[5]&1<<7 != 0
-	file.Header.Extended = data[5]&1<<6 != 0
-	file.Header.Experimental = data[5]&1<<5 != 0
-	file.Header.Footer = data[5]&1<<4 != 0
+	file.Header.Unsynchronization = data[5]&(1<<7) != 0
+	file.Header.Extended = data[5]&(1<<6) != 0
+	file.Header.Experimental = data[5]&(1<<5) != 0
+	file.Header.Footer = data[5]&(1<<4) != 0
 	file.Header.Size = parseSize(data[6:])
+	return nil
 }
@@ -16,8 +16,10 @@ package id3
 
 import (
 	"bytes"
+	"compress/zlib"
 	"os"
 	"path"
+	"runtime"
 	"testing"
 )
 
@@ -37,7 +39,7 @@ func testFile(t *testing.T, expected fileTest) {
 	}
 	defer fd.Close()
 
-	actual := Read(fd)
+	actual, _ := Read(fd)
 	if actual == nil {
 		t.Error("Could not parse ID3 information")
 		return
@@ -96,7 +98,7 @@ func testFile(t *testing.T, expected fileTest) {
 }
 
 func TestEmpty(t *testing.T) {
-	file := Read(new(bytes.Buffer))
+	file, _ := Read(new(bytes.Buffer))
 	if file != nil {
 		t.Fail()
 	}
@@ -104,20 +106,251 @@ func TestEmpty(t *testing.T) {
 
 func TestID3v220(t *testing.T) {
 	testFile(t, fileTest{"test_220.mp3", File{ID3v2Header{2, 0, false, false, false, false, 226741},
-		"There There", "Radiohead", "Hail To The Thief", "2003", "9", "", "Alternative", ""}})
+		"There There", "Radiohead", "Hail To The Thief", "2003", "9", "", "Alternative", "", "", false}})
 }
 
 func TestID3v230(t *testing.T) {
 	testFile(t, fileTest{"test_230.mp3", File{ID3v2Header{3, 0, false, false, false, false, 150717},
-		"Everything In Its Right Place", "Radiohead", "Kid A", "2000", "1", "", "Alternative", ""}})
+		"Everything In Its Right Place", "Radiohead", "Kid A", "2000", "1", "", "Alternative", "", "", false}})
 }
 
 func TestID3v240(t *testing.T) {
 	testFile(t, fileTest{"test_240.mp3", File{ID3v2Header{4, 0, false, false, false, false, 165126},
-		"Give Up The Ghost", "Radiohead", "The King Of Limbs", "2011", "07/08", "1/1", "Alternative", ""}})
+		"Give Up The Ghost", "Radiohead", "The King Of Limbs", "2011", "07/08", "1/1", "Alternative", "", "", false}})
 }
 
 func TestISO8859_1(t *testing.T) {
 	testFile(t, fileTest{"test_iso8859_1.mp3", File{ID3v2Header{3, 0, false, false, false, false, 273649},
-		"Pompeii Am Götterdämmerung", "The Flaming Lips", "At War With The Mystics", "2006", "11", "1/1", "Unknown", ""}})
+		"Pompeii Am Götterdämmerung", "The Flaming Lips", "At War With The Mystics", "2006", "11", "1/1", "Unknown", "", "", false}})
+}
+
+// Encodes n as a 4-byte sync-safe integer.
+func syncSafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// Makes a frame of an ID3v2.`version` tag.
+func makeFrame(version byte, id string, data []byte) []byte {
+	return makeFlaggedFrame(version, id, 0, data)
+}
+
+// Makes a frame with the given format flags, which v2.2 frames don't have.
+func makeFlaggedFrame(version byte, id string, flags byte, data []byte) []byte {
+	n := len(data)
+	switch version {
+	case 2:
+		return append(append([]byte(id), byte(n>>16), byte(n>>8), byte(n)), data...)
+	case 3:
+		return append(append([]byte(id), byte(n>>24), byte(n>>16), byte(n>>8), byte(n), 0, flags), data...)
+	default:
+		return append(append(append([]byte(id), syncSafe(n)...), 0, flags), data...)
+	}
+}
+
+// Makes an ID3v2.`version` tag with the given frames.
+func makeTag(version byte, frames ...[]byte) []byte {
+	return makeFlaggedTag(version, 0, frames...)
+}
+
+// Makes a tag with the given header flags, whose body is the given parts.
+func makeFlaggedTag(version byte, flags byte, parts ...[]byte) []byte {
+	var body []byte
+	for _, p := range parts {
+		body = append(body, p...)
+	}
+	tag := append([]byte{'I', 'D', '3', version, 0, flags}, syncSafe(len(body))...)
+	return append(tag, body...)
+}
+
+// Tags like those of the test files.
+func makeTestTags() [][]byte {
+	return [][]byte{
+		makeTag(2,
+			makeFrame(2, "TT2", []byte("\x00There There")),
+			makeFrame(2, "TP1", []byte("\x00Radiohead")),
+			makeFrame(2, "TCO", []byte("\x00(20)"))),
+		makeTag(3,
+			makeFrame(3, "TIT2", []byte("\x01\xff\xfeK\x00i\x00d\x00 \x00A\x00")),
+			makeFrame(3, "TRCK", []byte("\x001")),
+			makeFrame(3, "TCMP", []byte("\x001"))),
+		makeTag(4,
+			makeFrame(4, "TIT2", []byte("\x03Give Up The Ghost")),
+			makeFrame(4, "TPOS", []byte("\x001/1")),
+			makeFrame(4, "APIC", make([]byte, 200))),
+		makeTag(3, makeFrame(3, "TIT2", []byte("\x00Pompeii Am G\xf6tterd\xe4mmerung"))),
+	}
+}
+
+func TestReadTags(t *testing.T) {
+	for i, expected := range []File{
+		{Name: "There There", Artist: "Radiohead", Genre: "Alternative"},
+		{Name: "Kid A", Track: "1", Compilation: true},
+		{Name: "Give Up The Ghost", Disc: "1/1"},
+		{Name: "Pompeii Am Götterdämmerung"},
+	} {
+		data := makeTestTags()[i]
+		actual, err := Read(bytes.NewReader(data))
+		if err != nil || actual == nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		actual.Header = ID3v2Header{}
+		if *actual != expected {
+			t.Errorf("%d: expected %+v, got %+v", i, expected, *actual)
+		}
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	good := makeTestTags()[1]
+	huge := makeTag(3, makeFrame(3, "TIT2", []byte("\x00x")))
+	huge[14], huge[15], huge[16], huge[17] = 0x7f, 0xff, 0xff, 0xff
+	negative := append([]byte{}, huge...)
+	negative[14] = 0xff
+	for name, data := range map[string][]byte{
+		"truncated header": good[:7],
+		"truncated frame":  good[:len(good)-1],
+		"version 5":        append([]byte("ID3\x05"), good[4:]...),
+		"huge frame":       huge,
+		"negative frame":   negative,
+	} {
+		file, err := Read(bytes.NewReader(data))
+		if file != nil || err == nil {
+			t.Errorf("%s: expected an error, got %+v, %v", name, file, err)
+		}
+	}
+}
+
+func FuzzRead(f *testing.F) {
+	for _, data := range makeTestTags() {
+		f.Add(data)
+	}
+	// Keep seeds small, so that the fuzzer spends its time mutating them rather
+	// than minimizing them.
+	title := bytes.Repeat([]byte("\x00Title"), 512)
+	f.Add(makeTag(4, makeFlaggedFrame(4, "TIT2", 0x09, append(syncSafe(len(title)), compress(title)...))))
+	for _, name := range []string{"test_220.mp3", "test_230.mp3", "test_240.mp3", "test_iso8859_1.mp3"} {
+		if data, err := os.ReadFile(path.Join("..", "..", "test", name)); err == nil {
+			f.Add(data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if file, err := Read(bytes.NewReader(data)); file == nil && err == nil {
+			t.Error("no file and no error")
+		}
+	})
+}
+
+func TestReadAllocations(t *testing.T) {
+	large := make([]byte, maxDecompressedSize-1)
+	large[0] = 1
+	inputs := append(makeTestTags(), makeTag(4, makeFlaggedFrame(4, "TIT2", 0x09, append(syncSafe(len(large)), compress(large)...))))
+	for i, data := range inputs {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		Read(bytes.NewReader(data))
+		runtime.ReadMemStats(&after)
+		// Allow for buffers, for converting each byte to a rune, and for the same
+		// again for each byte that compressed frames decompress to.
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(64*len(data)+1<<20+16*maxDecompressedSize) {
+			t.Errorf("%d: allocated %d bytes for %d bytes of input", i, allocated, len(data))
+		}
+	}
+}
+
+// Puts a 0 after every 0xFF in data.
+func unsynchronize(data []byte) []byte {
+	var result []byte
+	for _, b := range data {
+		result = append(result, b)
+		if b == 0xff {
+			result = append(result, 0)
+		}
+	}
+	return result
+}
+
+func compress(data []byte) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+func TestReadFrameFormats(t *testing.T) {
+	name := []byte("\x00G\xf6tterd\xe4mmerung \xff\xfe")
+	artist := makeFrame(3, "TPE1", []byte("\x00Artist"))
+	artist24 := makeFrame(4, "TPE1", []byte("\x00Artist"))
+	compressed := compress(name)
+	size := []byte{0, 0, 0, byte(len(name))}
+
+	for test, data := range map[string][]byte{
+		"v2.3 unsynchronisation": makeFlaggedTag(3, 0x80, unsynchronize(append(makeFrame(3, "TIT2", name), artist...))),
+		"v2.3 extended header":   makeFlaggedTag(3, 0x40, []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0}, makeFrame(3, "TIT2", name), artist),
+		"v2.3 compression":       makeTag(3, makeFlaggedFrame(3, "TIT2", 0x80, append(size, compressed...)), artist),
+		"v2.3 grouping":          makeTag(3, makeFlaggedFrame(3, "TIT2", 0x20, append([]byte{7}, name...)), artist),
+		"v2.3 everything": makeFlaggedTag(3, 0xc0, unsynchronize(append(append([]byte{0, 0, 0, 6, 0xff, 0, 0, 0, 0, 0},
+			makeFlaggedFrame(3, "TIT2", 0xa0, append(append(append([]byte{}, size...), 7), compressed...))...), artist...))),
+		"v2.4 frame unsynchronisation": makeTag(4, makeFlaggedFrame(4, "TIT2", 0x02, unsynchronize(name)), artist24),
+		"v2.4 tag unsynchronisation":   makeFlaggedTag(4, 0x80, makeFrame(4, "TIT2", unsynchronize(name)), artist24),
+		"v2.4 extended header":         makeFlaggedTag(4, 0x40, append(syncSafe(6), 1, 0), makeFrame(4, "TIT2", name), artist24),
+		"v2.4 data length":             makeTag(4, makeFlaggedFrame(4, "TIT2", 0x01, append(syncSafe(len(name)), name...)), artist24),
+		"v2.4 compression":             makeTag(4, makeFlaggedFrame(4, "TIT2", 0x09, append(syncSafe(len(name)), compressed...)), artist24),
+		"v2.4 grouping":                makeTag(4, makeFlaggedFrame(4, "TIT2", 0x40, append([]byte{7}, name...)), artist24),
+		"v2.4 footer":                  append(makeFlaggedTag(4, 0x10, makeFrame(4, "TIT2", name), artist24), "3DI\x04\x00\x10\x00\x00\x00\x00"...),
+		"v2.4 everything": makeTag(4, makeFlaggedFrame(4, "TIT2", 0x4b,
+			append(append([]byte{7}, syncSafe(len(name))...), unsynchronize(compressed)...)), artist24),
+	} {
+		file, err := Read(bytes.NewReader(data))
+		if err != nil || file == nil {
+			t.Errorf("%s: %v", test, err)
+			continue
+		}
+		if file.Name != "Götterdämmerung ÿþ" || file.Artist != "Artist" {
+			t.Errorf("%s: got %q by %q", test, file.Name, file.Artist)
+		}
+	}
+}
+
+func TestReadSkipsEncryptedFrames(t *testing.T) {
+	for version, flag := range map[byte]byte{3: 0x40, 4: 0x04} {
+		data := makeTag(version,
+			makeFlaggedFrame(version, "TIT2", flag, []byte("\x01\x00secret")),
+			makeFrame(version, "TPE1", []byte("\x00Artist")))
+		file, err := Read(bytes.NewReader(data))
+		if err != nil || file == nil || file.Name != "" || file.Artist != "Artist" {
+			t.Errorf("v2.%d: expected only the artist, got %+v, %v", version, file, err)
+		}
+	}
+}
+
+func TestReadMalformedFrameFormats(t *testing.T) {
+	bomb := compress(make([]byte, maxDecompressedSize+1))
+	for name, data := range map[string][]byte{
+		"compressed v2.2 tag":    makeFlaggedTag(2, 0x40, makeFrame(2, "TT2", []byte("\x00x"))),
+		"decompression bomb":     makeTag(4, makeFlaggedFrame(4, "TIT2", 0x09, append(syncSafe(maxDecompressedSize+1), bomb...))),
+		"bad compressed data":    makeTag(3, makeFlaggedFrame(3, "TIT2", 0x80, []byte("\x00\x00\x00\x05hello"))),
+		"short data length":      makeTag(4, makeFlaggedFrame(4, "TIT2", 0x01, []byte{0, 0})),
+		"short extended header":  makeFlaggedTag(4, 0x40, syncSafe(2), makeFrame(4, "TIT2", []byte("\x00x"))),
+		"huge extended header":   makeFlaggedTag(3, 0x40, []byte{0x7f, 0, 0, 0}),
+		"truncated unsync'd tag": makeFlaggedTag(3, 0x80, makeFrame(3, "TIT2", []byte("\x00x")))[:15],
+	} {
+		file, err := Read(bytes.NewReader(data))
+		if file != nil || err == nil {
+			t.Errorf("%s: expected an error, got %+v, %v", name, file, err)
+		}
+	}
+}
+
+func TestReadHeaderFlags(t *testing.T) {
+	data := append(makeFlaggedTag(4, 0xf0, []byte{0, 0, 0, 6, 0, 0}), "3DI\x04\x00\xf0\x00\x00\x00\x06"...)
+	file, err := Read(bytes.NewReader(data))
+	if err != nil || file == nil {
+		t.Fatal(err)
+	}
+	expected := ID3v2Header{4, 0, true, true, true, true, 6}
+	if file.Header != expected {
+		t.Errorf("expected %+v, got %+v", expected, file.Header)
+	}
 }
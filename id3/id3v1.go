@@ -0,0 +1,136 @@
+// Copyright 2011 Andrew Scherkus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package id3
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// The ID3v1 tag is the last 128 bytes of the file.
+	id3v1Size = 128
+
+	// The Enhanced tag, if any, is the 227 bytes before the ID3v1 tag. Its
+	// title, artist, and album continue those in the ID3v1 tag.
+	id3v1EnhancedSize = 227
+)
+
+// Parses an ISO-8859-1 string from a fixed-size ID3v1 field, which is padded
+// with NULs or spaces.
+func parseID3v1String(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return strings.TrimRight(ISO8859_1ToUTF8(data), " ")
+}
+
+// Parses the ID3v1 or ID3v1.1 tag in `data`, and the Enhanced tag in
+// `enhanced`, if it is not nil.
+//
+// Refer to https://id3.org/ID3v1 and
+// https://en.wikipedia.org/wiki/ID3#Enhanced_tag
+func parseID3v1(data, enhanced []byte) *File {
+	file := new(File)
+	name, artist, album := data[3:33], data[33:63], data[63:93]
+	if enhanced != nil {
+		name = append(append([]byte{}, bytes.TrimRight(name, "\x00")...), enhanced[4:64]...)
+		artist = append(append([]byte{}, bytes.TrimRight(artist, "\x00")...), enhanced[64:124]...)
+		album = append(append([]byte{}, bytes.TrimRight(album, "\x00")...), enhanced[124:184]...)
+	}
+	file.Name = parseID3v1String(name)
+	file.Artist = parseID3v1String(artist)
+	file.Album = parseID3v1String(album)
+	file.Year = parseID3v1String(data[93:97])
+
+	// In ID3v1.1, the last 2 bytes of the comment are a 0 and the track number.
+	if data[125] == 0 && data[126] != 0 {
+		file.Track = strconv.Itoa(int(data[126]))
+	}
+
+	if enhanced != nil {
+		file.Genre = parseID3v1String(enhanced[185:215])
+	}
+	if file.Genre == "" && int(data[127]) < len(id3v1Genres) {
+		file.Genre = id3v1Genres[data[127]]
+	}
+	return file
+}
+
+// Reads the ID3v1 tag, and the Enhanced tag if there is one, at the end of
+// `reader`. The reader's position is restored afterward.
+func readID3v1(reader io.ReadSeeker) (*File, error) {
+	position, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Seek(position, io.SeekStart)
+
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size < id3v1Size {
+		return nil, errors.New("Insufficient data for an ID3v1 tag")
+	}
+
+	length := int64(id3v1Size)
+	if size >= id3v1Size+id3v1EnhancedSize {
+		length += id3v1EnhancedSize
+	}
+	data := make([]byte, length)
+	if _, err := reader.Seek(-length, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+
+	tag := data[len(data)-id3v1Size:]
+	if string(tag[:3]) != "TAG" {
+		return nil, errors.New("Not an ID3v1 tag")
+	}
+	var enhanced []byte
+	if len(data) > id3v1Size && string(data[:4]) == "TAG+" {
+		enhanced = data[:id3v1EnhancedSize]
+	}
+	return parseID3v1(tag, enhanced), nil
+}
+
+// Fills in the fields of `file` that are empty with those of `other`.
+func (file *File) merge(other *File) {
+	for _, f := range []struct {
+		to   *string
+		from string
+	}{
+		{&file.Name, other.Name},
+		{&file.Artist, other.Artist},
+		{&file.Album, other.Album},
+		{&file.Year, other.Year},
+		{&file.Track, other.Track},
+		{&file.Disc, other.Disc},
+		{&file.Genre, other.Genre},
+		{&file.Length, other.Length},
+		{&file.AlbumArtist, other.AlbumArtist},
+	} {
+		if *f.to == "" {
+			*f.to = f.from
+		}
+	}
+	file.Compilation = file.Compilation || other.Compilation
+}
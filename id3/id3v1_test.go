@@ -0,0 +1,123 @@
+// Copyright 2011 Andrew Scherkus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func makeID3v1Tag(name, artist, album, year string, track, genre byte) []byte {
+	tag := make([]byte, id3v1Size)
+	copy(tag, "TAG")
+	copy(tag[3:33], name)
+	copy(tag[33:63], artist)
+	copy(tag[63:93], album)
+	copy(tag[93:97], year)
+	copy(tag[97:125], "A comment")
+	tag[126] = track
+	tag[127] = genre
+	return tag
+}
+
+func makeID3v23Tag(frames map[string]string) []byte {
+	var body bytes.Buffer
+	for id, value := range frames {
+		body.WriteString(id)
+		binary.Write(&body, binary.BigEndian, uint32(1+len(value)))
+		body.Write([]byte{0, 0, 0})
+		body.WriteString(value)
+	}
+	body.Write(make([]byte, 16))
+	size := body.Len()
+	header := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(header, body.Bytes()...)
+}
+
+func checkFile(t *testing.T, actual *File, expected File) {
+	t.Helper()
+	if actual == nil {
+		t.Fatal("Could not parse ID3 information")
+	}
+	actual.Header = expected.Header
+	if *actual != expected {
+		t.Errorf("expected %+v got %+v", expected, *actual)
+	}
+}
+
+func TestID3v1(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x00}, 100)
+	data := append(append([]byte{}, audio...), makeID3v1Tag("Hells Bells", "AC/DC", "Back in Black", "1980", 0, 9)...)
+	file, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, file, File{Name: "Hells Bells", Artist: "AC/DC", Album: "Back in Black", Year: "1980", Genre: "Metal"})
+
+	// ID3v1.1 has a track number.
+	data = append(append([]byte{}, audio...), makeID3v1Tag("Hells Bells", "AC/DC", "Back in Black", "1980", 1, 255)...)
+	file, _ = Read(bytes.NewReader(data))
+	checkFile(t, file, File{Name: "Hells Bells", Artist: "AC/DC", Album: "Back in Black", Year: "1980", Track: "1"})
+
+	// Without seeking, there is no way to find the tag.
+	if file, _ := Read(bytes.NewBuffer(data)); file != nil {
+		t.Errorf("expected nothing from a plain reader, got %+v", *file)
+	}
+	if file, err := Read(bytes.NewReader(audio)); file != nil || err == nil {
+		t.Errorf("expected an error without any tag, got %+v", file)
+	}
+}
+
+func TestID3v1Enhanced(t *testing.T) {
+	name := "A Very Long Title That Does Not Fit In Thirty Characters"
+	enhanced := make([]byte, id3v1EnhancedSize)
+	copy(enhanced, "TAG+")
+	copy(enhanced[4:64], name[30:])
+	copy(enhanced[124:184], " and More")
+	copy(enhanced[185:215], "Post-Rock")
+	data := append(make([]byte, 1000), enhanced...)
+	data = append(data, makeID3v1Tag(name[:30], "Artist", "The Album", "2001", 2, 9)...)
+
+	file, _ := Read(bytes.NewReader(data))
+	checkFile(t, file, File{Name: name, Artist: "Artist", Album: "The Album and More", Year: "2001", Track: "2", Genre: "Post-Rock"})
+}
+
+func TestID3v1MergesWithID3v2(t *testing.T) {
+	data := makeID3v23Tag(map[string]string{"TIT2": "Real Name", "TALB": "Real Album"})
+	data = append(data, make([]byte, 1000)...)
+	data = append(data, makeID3v1Tag("Truncated Nam", "Artist", "Truncated Alb", "1999", 3, 255)...)
+
+	file, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Header.Version != 3 {
+		t.Errorf("expected the ID3v2 header, got %+v", file.Header)
+	}
+	checkFile(t, file, File{Name: "Real Name", Artist: "Artist", Album: "Real Album", Year: "1999", Track: "3"})
+}
+
+func TestID3v1WithMalformedID3v2(t *testing.T) {
+	data := []byte("ID3\x05\x00\x00\x00\x00\x00\x10")
+	data = append(data, make([]byte, 1000)...)
+	data = append(data, makeID3v1Tag("Name", "Artist", "Album", "1999", 3, 255)...)
+
+	file, err := Read(bytes.NewReader(data))
+	if err == nil || err == ErrNoTag {
+		t.Errorf("expected the ID3v2 error, got %v", err)
+	}
+	checkFile(t, file, File{Name: "Name", Artist: "Artist", Album: "Album", Year: "1999", Track: "3"})
+}
@@ -18,34 +18,53 @@ import (
 	"bufio"
 )
 
-// ID3 v2.2 uses 24-bit big endian frame sizes.
-func parseID3v22FrameSize(reader *bufio.Reader) int {
-	size := readBytes(reader, 3)
-	return int(size[0])<<16 | int(size[1])<<8 | int(size[2])
+func parseID3v22FrameSize(reader *bufio.Reader) (int, error) {
+	size, err := readBytes(reader, 3)
+	if err != nil {
+		return 0, err
+	}
+	return int(size[0])<<16 | int(size[1])<<8 | int(size[2]), nil
+}
+
+// The fields of File that ID3v2.2 frames set, by frame ID.
+var id3v22Frames = map[string]func(*File, string){
+	"TAL": func(f *File, s string) { f.Album = s },
+	"TRK": func(f *File, s string) { f.Track = s },
+	"TP1": func(f *File, s string) { f.Artist = s },
+	"TT2": func(f *File, s string) { f.Name = s },
+	"TYE": func(f *File, s string) { f.Year = s },
+	"TPA": func(f *File, s string) { f.Disc = s },
+	"TCO": func(f *File, s string) { f.Genre = convertID3v1Genre(s) },
+	"TP2": func(f *File, s string) { f.AlbumArtist = s },
+	"TCP": func(f *File, s string) { f.Compilation = s == "1" },
 }
 
-func parseID3v22File(reader *bufio.Reader, file *File) {
+func parseID3v22File(reader *bufio.Reader, file *File) error {
 	for hasFrame(reader, 3) {
-		id := string(readBytes(reader, 3))
-		size := parseID3v22FrameSize(reader)
+		id, err := readBytes(reader, 3)
+		if err != nil {
+			return err
+		}
+		size, err := parseID3v22FrameSize(reader)
+		if err != nil {
+			return err
+		}
+		if size > int(file.Header.Size) {
+			return errFrameSize
+		}
 
-		switch id {
-		case "TAL":
-			file.Album = readString(reader, size)
-		case "TRK":
-			file.Track = readString(reader, size)
-		case "TP1":
-			file.Artist = readString(reader, size)
-		case "TT2":
-			file.Name = readString(reader, size)
-		case "TYE":
-			file.Year = readString(reader, size)
-		case "TPA":
-			file.Disc = readString(reader, size)
-		case "TCO":
-			file.Genre = readGenre(reader, size)
-		default:
-			skipBytes(reader, size)
+		set, ok := id3v22Frames[string(id)]
+		if !ok {
+			if err := skipBytes(reader, size); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := readBytes(reader, size)
+		if err != nil {
+			return err
 		}
+		set(file, parseString(data))
 	}
+	return nil
 }
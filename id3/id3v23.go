@@ -20,39 +20,94 @@ import (
 )
 
 // ID3 v2.3 doesn't use sync-safe frame sizes: read in as a regular big endian number.
-func parseID3v23Size(reader *bufio.Reader) int {
+func parseID3v23Size(reader *bufio.Reader) (int, error) {
 	var size int32
-	binary.Read(reader, binary.BigEndian, &size)
-	return int(size)
+	if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+		return 0, err
+	}
+	if size < 0 {
+		return 0, errFrameSize
+	}
+	return int(size), nil
 }
 
-func parseID3v23File(reader *bufio.Reader, file *File) {
+// The fields of File that ID3v2.3 frames set, by frame ID.
+var id3v23Frames = map[string]func(*File, string){
+	"TALB": func(f *File, s string) { f.Album = s },
+	"TRCK": func(f *File, s string) { f.Track = s },
+	"TPE1": func(f *File, s string) { f.Artist = s },
+	"TCON": func(f *File, s string) { f.Genre = convertID3v1Genre(s) },
+	"TIT2": func(f *File, s string) { f.Name = s },
+	"TYER": func(f *File, s string) { f.Year = s },
+	"TPOS": func(f *File, s string) { f.Disc = s },
+	"TLEN": func(f *File, s string) { f.Length = s },
+	"TPE2": func(f *File, s string) { f.AlbumArtist = s },
+	"TCMP": func(f *File, s string) { f.Compilation = s == "1" },
+}
+
+// ID3v2.3 frame format flags, in the second flags byte.
+//
+// Refer to section 3.3.1 of http://id3.org/id3v2.3.0
+const (
+	id3v23Compressed = 0x80
+	id3v23Encrypted  = 0x40
+	id3v23Grouped    = 0x20
+)
+
+func parseID3v23File(reader *bufio.Reader, file *File) error {
+	if err := skipExtendedHeader(reader, file.Header); err != nil {
+		return err
+	}
+
+	var decoder frameDecoder
 	for hasFrame(reader, 4) {
-		id := string(readBytes(reader, 4))
-		size := parseID3v23Size(reader)
-
-		// Skip over frame flags.
-		skipBytes(reader, 2)
-
-		switch id {
-		case "TALB":
-			file.Album = readString(reader, size)
-		case "TRCK":
-			file.Track = readString(reader, size)
-		case "TPE1":
-			file.Artist = readString(reader, size)
-		case "TCON":
-			file.Genre = readGenre(reader, size)
-		case "TIT2":
-			file.Name = readString(reader, size)
-		case "TYER":
-			file.Year = readString(reader, size)
-		case "TPOS":
-			file.Disc = readString(reader, size)
-		case "TLEN":
-			file.Length = readString(reader, size)
-		default:
-			skipBytes(reader, size)
+		id, err := readBytes(reader, 4)
+		if err != nil {
+			return err
+		}
+		size, err := parseID3v23Size(reader)
+		if err != nil {
+			return err
+		}
+		if size > int(file.Header.Size) {
+			return errFrameSize
+		}
+		flags, err := readBytes(reader, 2)
+		if err != nil {
+			return err
+		}
+
+		set, ok := id3v23Frames[string(id)]
+		if !ok || flags[1]&id3v23Encrypted != 0 {
+			// We can't decrypt frames, so they are as good as unknown.
+			if err := skipBytes(reader, size); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := readBytes(reader, size)
+		if err != nil {
+			return err
+		}
+
+		// The decompressed size and the group ID come before the frame data.
+		extra := 0
+		if flags[1]&id3v23Compressed != 0 {
+			extra += 4
+		}
+		if flags[1]&id3v23Grouped != 0 {
+			extra++
+		}
+		if extra > len(data) {
+			return errFrameSize
+		}
+		data = data[extra:]
+		if flags[1]&id3v23Compressed != 0 {
+			if data, err = decoder.decompress(data); err != nil {
+				return err
+			}
 		}
+		set(file, parseString(data))
 	}
+	return nil
 }
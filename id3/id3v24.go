@@ -19,38 +19,99 @@ import (
 )
 
 // ID3 v2.4 uses sync-safe frame sizes similar to those found in the header.
-func parseID3v24Size(reader *bufio.Reader) int {
-	return int(parseSize(readBytes(reader, 4)))
+func parseID3v24Size(reader *bufio.Reader) (int, error) {
+	data, err := readBytes(reader, 4)
+	if err != nil {
+		return 0, err
+	}
+	return int(parseSize(data)), nil
+}
+
+// The fields of File that ID3v2.4 frames set, by frame ID.
+var id3v24Frames = map[string]func(*File, string){
+	"TALB": func(f *File, s string) { f.Album = s },
+	"TRCK": func(f *File, s string) { f.Track = s },
+	"TPE1": func(f *File, s string) { f.Artist = s },
+	"TCON": func(f *File, s string) { f.Genre = convertID3v1Genre(s) },
+	"TIT2": func(f *File, s string) { f.Name = s },
+	// TODO: implement timestamp parsing
+	"TDRC": func(f *File, s string) { f.Year = s },
+	"TPOS": func(f *File, s string) { f.Disc = s },
+	"TLEN": func(f *File, s string) { f.Length = s },
+	"TPE2": func(f *File, s string) { f.AlbumArtist = s },
+	"TCMP": func(f *File, s string) { f.Compilation = s == "1" },
 }
 
-func parseID3v24File(reader *bufio.Reader, file *File) {
+// ID3v2.4 frame format flags, in the second flags byte.
+//
+// Refer to section 4.1.2 of http://id3.org/id3v2.4.0-structure
+const (
+	id3v24Grouped        = 0x40
+	id3v24Compressed     = 0x08
+	id3v24Encrypted      = 0x04
+	id3v24Unsynchronized = 0x02
+	id3v24HasDataLength  = 0x01
+)
+
+func parseID3v24File(reader *bufio.Reader, file *File) error {
+	if err := skipExtendedHeader(reader, file.Header); err != nil {
+		return err
+	}
+
+	var decoder frameDecoder
 	for hasFrame(reader, 4) {
-		id := string(readBytes(reader, 4))
-		size := parseID3v24Size(reader)
-
-		// Skip over frame flags.
-		skipBytes(reader, 2)
-
-		switch id {
-		case "TALB":
-			file.Album = readString(reader, size)
-		case "TRCK":
-			file.Track = readString(reader, size)
-		case "TPE1":
-			file.Artist = readString(reader, size)
-		case "TCON":
-			file.Genre = readGenre(reader, size)
-		case "TIT2":
-			file.Name = readString(reader, size)
-		case "TDRC":
-			// TODO: implement timestamp parsing
-			file.Year = readString(reader, size)
-		case "TPOS":
-			file.Disc = readString(reader, size)
-		case "TLEN":
-			file.Length = readString(reader, size)
-		default:
-			skipBytes(reader, size)
+		id, err := readBytes(reader, 4)
+		if err != nil {
+			return err
+		}
+		size, err := parseID3v24Size(reader)
+		if err != nil {
+			return err
+		}
+		if size > int(file.Header.Size) {
+			return errFrameSize
+		}
+		flags, err := readBytes(reader, 2)
+		if err != nil {
+			return err
+		}
+
+		set, ok := id3v24Frames[string(id)]
+		if !ok || flags[1]&id3v24Encrypted != 0 {
+			// We can't decrypt frames, so they are as good as unknown.
+			if err := skipBytes(reader, size); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := readBytes(reader, size)
+		if err != nil {
+			return err
+		}
+
+		// The group ID and the data length indicator come before the frame data.
+		extra := 0
+		if flags[1]&id3v24Grouped != 0 {
+			extra++
+		}
+		if flags[1]&id3v24HasDataLength != 0 {
+			extra += 4
+		}
+		if extra > len(data) {
+			return errFrameSize
+		}
+		data = data[extra:]
+		// In v2.4, unsynchronisation is per frame. The tag flag means that every
+		// frame is unsynchronised, even if the frame doesn't say so.
+		if flags[1]&id3v24Unsynchronized != 0 || file.Header.Unsynchronization {
+			data = removeUnsynchronization(data)
+		}
+		if flags[1]&id3v24Compressed != 0 {
+			if data, err = decoder.decompress(data); err != nil {
+				return err
+			}
 		}
+		set(file, parseString(data))
 	}
+	return nil
 }
@@ -16,7 +16,7 @@ package main
 
 import (
 	"fmt"
-	"id3"
+	"noncombatant.org/id3"
 	"os"
 )
 
@@ -32,12 +32,12 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Could not open %s: %s\n", s, err)
 			return
 		}
-		file := id3.Read(fd)
+		file, err := id3.Read(fd)
 		if file == nil {
-			fmt.Fprintf(os.Stderr, "Could not read ID3 information from %s\n", s)
+			fmt.Fprintf(os.Stderr, "Could not read ID3 information from %s: %v\n", s, err)
 		} else {
 			fmt.Println(s)
-			fmt.Printf("Header\t%s\n", file.Header)
+			fmt.Printf("Header\t%+v\n", file.Header)
 			fmt.Printf("Name\t%s\n", file.Name)
 			fmt.Printf("Artist\t%s\n", file.Artist)
 			fmt.Printf("Album\t%s\n", file.Album)
@@ -16,13 +16,22 @@ package id3
 
 import (
 	"bufio"
-	"fmt"
-	"os"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
 	"strings"
 	"unicode/utf16"
 )
 
-var skipBuffer []byte = make([]byte, 1024*4)
+var errFrameSize = errors.New("Frame size is negative or larger than the tag")
+
+// The most that the compressed frames of one tag may decompress to. More than
+// this is assumed to be malformed, rather than decompressed into memory.
+const maxDecompressedSize = 1 << 20
+
+var errDecompressedSize = errors.New("Compressed frames are too large")
 
 func ISO8859_1ToUTF8(data []byte) string {
 	p := make([]rune, len(data))
@@ -34,7 +43,7 @@ func ISO8859_1ToUTF8(data []byte) string {
 
 func toUTF16(data []byte) []uint16 {
 	if len(data) < 2 {
-		fmt.Fprintf(os.Stderr, "Sequence is too short too contain a UTF-16 BOM")
+		// Too short to contain a UTF-16 BOM.
 		return nil
 	}
 	if len(data)%2 > 0 {
@@ -127,40 +136,97 @@ func parseString(data []byte) string {
 	return strings.TrimRight(s, "\u0000")
 }
 
-func readBytes(reader *bufio.Reader, c int) []byte {
-	b := make([]byte, c)
-	pos := 0
-	for pos < c {
-		i, err := reader.Read(b[pos:])
-		pos += i
-		if err != nil {
-			panic(err)
-		}
+// Reads exactly c bytes. The buffer grows as the data arrives, so that a bogus
+// size in a short file doesn't allocate more than the file holds.
+func readBytes(reader *bufio.Reader, c int) ([]byte, error) {
+	if c < 0 {
+		return nil, errFrameSize
 	}
-	return b
+	b, err := io.ReadAll(io.LimitReader(reader, int64(c)))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < c {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
 }
 
-func readString(reader *bufio.Reader, c int) string {
-	return parseString(readBytes(reader, c))
+func skipBytes(reader *bufio.Reader, c int) error {
+	if c < 0 {
+		return errFrameSize
+	}
+	n, err := io.CopyN(io.Discard, reader, int64(c))
+	if n < int64(c) && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
 }
 
-func readGenre(reader *bufio.Reader, c int) string {
-	genre := parseString(readBytes(reader, c))
-	return convertID3v1Genre(genre)
+// Skips the extended header, if there is one. In v2.3, its size doesn't
+// include the size itself; in v2.4, it does, and is sync-safe.
+//
+// Refer to section 3.2 of http://id3.org/id3v2.3.0 and section 3.2 of
+// http://id3.org/id3v2.4.0-structure
+func skipExtendedHeader(reader *bufio.Reader, header ID3v2Header) error {
+	if !header.Extended {
+		return nil
+	}
+	data, err := readBytes(reader, 4)
+	if err != nil {
+		return err
+	}
+	if header.Version == 3 {
+		return skipBytes(reader, int(int32(binary.BigEndian.Uint32(data))))
+	}
+	size := int(parseSize(data))
+	if size < 6 {
+		return errFrameSize
+	}
+	return skipBytes(reader, size-4)
 }
 
-func skipBytes(reader *bufio.Reader, c int) {
-	pos := 0
-	for pos < c {
-		end := c - pos
-		if end > len(skipBuffer) {
-			end = len(skipBuffer)
+// Undoes unsynchronisation, which puts a 0 after every 0xFF so that tags
+// contain nothing that looks like the start of an MPEG frame.
+//
+// Refer to section 6.1 of http://id3.org/id3v2.4.0-structure
+func removeUnsynchronization(data []byte) []byte {
+	result := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		result = append(result, data[i])
+		if data[i] == 0xff && i+1 < len(data) && data[i+1] == 0 {
+			i++
 		}
+	}
+	return result
+}
 
-		i, err := reader.Read(skipBuffer[0:end])
-		pos += i
-		if err != nil {
-			panic(err)
-		}
+// A frameDecoder decompresses the zlib-compressed frames of a tag. It reuses
+// its zlib reader from frame to frame, and limits the total size of the
+// decompressed frames to maxDecompressedSize.
+type frameDecoder struct {
+	zlib         io.ReadCloser
+	decompressed int
+}
+
+func (d *frameDecoder) decompress(data []byte) ([]byte, error) {
+	var err error
+	if d.zlib == nil {
+		d.zlib, err = zlib.NewReader(bytes.NewReader(data))
+	} else {
+		err = d.zlib.(zlib.Resetter).Reset(bytes.NewReader(data), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	limit := maxDecompressedSize - d.decompressed
+	result, err := io.ReadAll(io.LimitReader(d.zlib, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(result) > limit {
+		return nil, errDecompressedSize
 	}
+	d.decompressed += len(result)
+	return result, nil
 }
@@ -0,0 +1,239 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Ignore files can be anywhere in a music directory, and apply to the
+	// directory they are in and those beneath it.
+	ignoreBasename = ".beanignore"
+
+	// The global ignore file is in the configuration directory, and applies to
+	// every music directory.
+	globalIgnoreBasename = "ignore"
+)
+
+// An ignoreRule is one line of an ignore file, in gitignore syntax: `*`, `?`,
+// and `[...]` match within a pathname component, and `**` matches any number
+// of components. A leading `!` re-includes what an earlier rule excluded. A
+// trailing `/` matches only directories. A pattern that contains a `/` other
+// than at the end is relative to the directory of the ignore file; otherwise
+// it matches at any depth.
+type ignoreRule struct {
+	Source   string
+	Pattern  string
+	negated  bool
+	dirOnly  bool
+	segments []string
+}
+
+func (r *ignoreRule) String() string {
+	return fmt.Sprintf("%s: %s", r.Source, r.Pattern)
+}
+
+// parseIgnoreRule parses `line` from the ignore file at `source`. It returns
+// nil if the line is blank or a comment.
+func parseIgnoreRule(source, line string) *ignoreRule {
+	pattern := strings.TrimRight(line, " \t\r")
+	if pattern == "" || pattern[0] == '#' {
+		return nil
+	}
+	r := ignoreRule{Source: source, Pattern: pattern}
+	if pattern[0] == '!' {
+		r.negated = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil
+	}
+	anchored := strings.Contains(pattern, "/")
+	r.segments = strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	if !anchored {
+		r.segments = append([]string{"**"}, r.segments...)
+	}
+	return &r
+}
+
+// readIgnoreFile returns the rules in the ignore file at `pathname`.
+func readIgnoreFile(pathname string) ([]*ignoreRule, error) {
+	file, e := os.Open(pathname)
+	if e != nil {
+		return nil, e
+	}
+	defer file.Close()
+
+	var rules []*ignoreRule
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		if r := parseIgnoreRule(fmt.Sprintf("%s:%d", pathname, n), scanner.Text()); r != nil {
+			rules = append(rules, r)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, e := path.Match(pattern[0], segments[0]); e != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// matches returns true if the rule matches `relative`, a slash-separated
+// pathname relative to the directory of the rule's ignore file.
+func (r *ignoreRule) matches(relative string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(r.segments, strings.Split(relative, "/"))
+}
+
+// An ignoreMatcher decides which files and directories a scan should ignore.
+// It reads ignore files as it needs them, and counts how many things each rule
+// excluded. It is not safe for concurrent use.
+type ignoreMatcher struct {
+	global []*ignoreRule
+	byDir  map[string][]*ignoreRule
+	counts map[*ignoreRule]*ignoreCount
+	order  []*ignoreRule
+	log    func(error)
+}
+
+func newIgnoreMatcher(global []*ignoreRule, log func(error)) *ignoreMatcher {
+	return &ignoreMatcher{global: global, byDir: map[string][]*ignoreRule{}, counts: map[*ignoreRule]*ignoreCount{}, log: log}
+}
+
+func (m *ignoreMatcher) getDirectoryRules(dir string) []*ignoreRule {
+	rules, ok := m.byDir[dir]
+	if !ok {
+		var e error
+		rules, e = readIgnoreFile(filepath.Join(dir, ignoreBasename))
+		if e != nil && !os.IsNotExist(e) {
+			m.log(e)
+		}
+		m.byDir[dir] = rules
+	}
+	return rules
+}
+
+// match returns the rule that excludes `pathname`, which is in `root`, or nil
+// if it is not excluded. Global rules come first, and then those of each
+// directory from `root` down; the last rule that matches decides.
+func (m *ignoreMatcher) match(root, pathname string, isDir bool) *ignoreRule {
+	if pathname == root {
+		return nil
+	}
+	var decider *ignoreRule
+	apply := func(rules []*ignoreRule, base string) {
+		relative := filepath.ToSlash(pathname[len(base)+1:])
+		for _, r := range rules {
+			if r.matches(relative, isDir) {
+				decider = r
+			}
+		}
+	}
+	apply(m.global, root)
+	dir := root
+	for {
+		apply(m.getDirectoryRules(dir), dir)
+		rest := pathname[len(dir)+1:]
+		i := strings.IndexRune(rest, os.PathSeparator)
+		if i < 0 {
+			break
+		}
+		dir = pathname[:len(dir)+1+i]
+	}
+	if decider == nil || decider.negated {
+		return nil
+	}
+	return decider
+}
+
+// excludes returns true if `pathname`, or any directory above it in `root`, is
+// excluded. Unlike `shouldIgnore`, it does not count anything.
+func (m *ignoreMatcher) excludes(root, pathname string, isDir bool) bool {
+	if pathname == root {
+		return false
+	}
+	dir := root
+	for {
+		rest := pathname[len(dir)+1:]
+		i := strings.IndexRune(rest, os.PathSeparator)
+		if i < 0 {
+			break
+		}
+		dir = pathname[:len(dir)+1+i]
+		if m.match(root, dir, true) != nil {
+			return true
+		}
+	}
+	return m.match(root, pathname, isDir) != nil
+}
+
+// shouldIgnore returns true if `pathname` is excluded, and counts it against
+// the rule that excluded it.
+func (m *ignoreMatcher) shouldIgnore(root, pathname string, isDir bool) bool {
+	r := m.match(root, pathname, isDir)
+	if r == nil {
+		return false
+	}
+	c, ok := m.counts[r]
+	if !ok {
+		c = &ignoreCount{Rule: r.String()}
+		m.counts[r] = c
+		m.order = append(m.order, r)
+	}
+	if isDir {
+		c.Directories++
+	} else {
+		c.Files++
+	}
+	return true
+}
+
+// An ignoreCount is the number of media files and directories that a rule
+// excluded. (The contents of excluded directories are not counted.)
+type ignoreCount struct {
+	Rule        string `json:"rule"`
+	Files       int    `json:"files"`
+	Directories int    `json:"directories"`
+}
+
+// getCounts returns the counts for each rule that excluded anything, in the
+// order the rules first excluded something.
+func (m *ignoreMatcher) getCounts() []ignoreCount {
+	counts := make([]ignoreCount, len(m.order))
+	for i, r := range m.order {
+		counts[i] = *m.counts[r]
+	}
+	return counts
+}
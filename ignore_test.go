@@ -0,0 +1,103 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"io"
+	"log"
+	"path"
+	"testing"
+)
+
+func TestIgnoreRuleMatches(t *testing.T) {
+	for _, c := range []struct {
+		pattern  string
+		relative string
+		isDir    bool
+		expected bool
+	}{
+		{"*.wav", "a.wav", false, true},
+		{"*.wav", "Artist/Album/a.wav", false, true},
+		{"*.wav", "a.mp3", false, false},
+		{"Stems/", "Artist/Stems", true, true},
+		{"Stems/", "Artist/Stems", false, false},
+		{"/Stems", "Artist/Stems", true, false},
+		{"/Stems", "Stems", true, true},
+		{"Artist/Stems", "Artist/Stems", true, true},
+		{"Artist/Stems", "Other/Artist/Stems", true, false},
+		{"**/Stems", "Other/Artist/Stems", true, true},
+		{"Artist/**/*.wav", "Artist/a.wav", false, true},
+		{"Artist/**/*.wav", "Artist/Album/Disc 1/a.wav", false, true},
+		{"Track ?.mp3", "Track 1.mp3", false, true},
+		{"Track ?.mp3", "Track 10.mp3", false, false},
+		{"[Ss]ession*", "session 2", true, true},
+		{`\#1.mp3`, "#1.mp3", false, true},
+	} {
+		r := parseIgnoreRule("test:1", c.pattern)
+		if r == nil {
+			t.Errorf("%q: not parsed", c.pattern)
+			continue
+		}
+		if got := r.matches(c.relative, c.isDir); got != c.expected {
+			t.Errorf("%q matching %q: expected %v, got %v", c.pattern, c.relative, c.expected, got)
+		}
+	}
+
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		if r := parseIgnoreRule("test:1", line); r != nil {
+			t.Errorf("%q: expected no rule, got %q", line, r.Pattern)
+		}
+	}
+}
+
+func TestNewCatalogIgnores(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	writeTestFile(t, path.Join(root, "Artist/Album/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(root, "Artist/Album/01 One.wav"), "one")
+	writeTestFile(t, path.Join(root, "Artist/Album/Keep.wav"), "keep")
+	writeTestFile(t, path.Join(root, "Artist/Session/01 Take.mp3"), "take")
+	writeTestFile(t, path.Join(root, "Artist/Session/02 Take.mp3"), "take")
+	writeTestFile(t, path.Join(root, "Other/Album/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(root, "Other/Album/Bounce.mp3"), "bounce")
+	writeTestFile(t, path.Join(root, ".beanignore"), "# Stems and sessions\n*.wav\nSession/\n")
+	writeTestFile(t, path.Join(root, "Artist/Album/.beanignore"), "!Keep.wav\n")
+	global := []*ignoreRule{parseIgnoreRule("global:1", "Bounce*")}
+
+	c, report := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 2, IgnoreRules: global})
+	expected := []string{
+		"Artist/Album/01%20One.mp3",
+		"Artist/Album/Keep.wav",
+		"Other/Album/01%20One.mp3",
+	}
+	if len(c.ItemInfos) != len(expected) {
+		t.Fatalf("expected %d items, got %d", len(expected), len(c.ItemInfos))
+	}
+	for i, pathname := range expected {
+		if c.ItemInfos[i].Pathname != pathname {
+			t.Errorf("%d: expected %q, got %q", i, pathname, c.ItemInfos[i].Pathname)
+		}
+	}
+
+	expectedCounts := []ignoreCount{
+		{Rule: path.Join(root, ".beanignore") + ":2: *.wav", Files: 1},
+		{Rule: path.Join(root, ".beanignore") + ":3: Session/", Directories: 1},
+		{Rule: "global:1: Bounce*", Files: 1},
+	}
+	if len(report.Ignored) != len(expectedCounts) {
+		t.Fatalf("expected %d ignore counts, got %v", len(expectedCounts), report.Ignored)
+	}
+	for i, count := range expectedCounts {
+		if report.Ignored[i] != count {
+			t.Errorf("%d: expected %v, got %v", i, count, report.Ignored[i])
+		}
+	}
+
+	// Newly ignoring a directory removes its items when the watcher notices.
+	writeTestFile(t, path.Join(root, "Other/.beanignore"), "Album/\n")
+	u := c.withChanges(logger, musicRoots{{Pathname: root}}, scanOptions{IgnoreRules: global}, newTreeWalker(nil, false), []string{path.Join(root, "Other")})
+	if len(u.ItemInfos) != 2 {
+		t.Errorf("expected 2 items, got %d", len(u.ItemInfos))
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// The size of each part of the audio payload that `readItemID` hashes.
+const itemIDWindowSize = 64 * 1024
+
+// getAudioPayload returns the extent of the audio in `r`, excluding any tags.
+// If the format is not known, or has no separate tags, it is the whole file.
+func getAudioPayload(pathname string, r io.ReadSeeker, size int64) (int64, int64, error) {
+	switch getBasenameExtension(pathname) {
+	case ".mp3":
+		start, e := getID3v2Size(r)
+		if e != nil {
+			return 0, 0, e
+		}
+		// Leave out the ID3v1 tag, and the Enhanced tag before it.
+		end := size
+		var tail [4]byte
+		if end-start >= 128 && readAt(r, end-128, tail[:3]) == nil && string(tail[:3]) == "TAG" {
+			end -= 128
+			if end-start >= 227 && readAt(r, end-227, tail[:]) == nil && string(tail[:]) == "TAG+" {
+				end -= 227
+			}
+		}
+		return start, end, nil
+	case ".flac":
+		start, e := walkFLACMetadata(r, size, func(byte, int64, int64) error { return nil })
+		return start, size, e
+	case ".m4a", ".m4v", ".mov", ".mp4":
+		// Tags are in the `moov` atom; the media are in the largest `mdat`.
+		var start, end int64
+		e := readMP4Atoms(r, 0, size, func(kind string, s, e int64) error {
+			if kind == "mdat" && e-s > end-start {
+				start, end = s, e
+			}
+			return nil
+		})
+		if e == nil && end == 0 {
+			e = errNoAudioStream
+		}
+		return start, end, e
+	case ".wav", ".wave":
+		var start, end int64
+		e := walkRIFFChunks(r, size, func(kind string, s, e int64) error {
+			if kind == "data" {
+				start, end = s, e
+			}
+			return nil
+		})
+		if e == nil && end == 0 {
+			e = errNoAudioStream
+		}
+		return start, end, e
+	}
+	return 0, size, nil
+}
+
+// hashWindows hashes the length of the extent from `start` to `end` in `r`,
+// and its beginning, middle, and end, each `window` bytes long. If the extent
+// is no longer than 3 windows, all of it is hashed.
+func hashWindows(h hash.Hash, r io.ReadSeeker, start, end, window int64) error {
+	binary.Write(h, binary.BigEndian, end-start)
+	offsets := []int64{start}
+	if end-start > 3*window {
+		offsets = append(offsets, start+(end-start-window)/2, end-window)
+	}
+	buffer := make([]byte, itemIDWindowSize)
+	for _, offset := range offsets {
+		length := end - offset
+		if length > window && len(offsets) > 1 {
+			length = window
+		}
+		if _, e := r.Seek(offset, io.SeekStart); e != nil {
+			return e
+		}
+		if _, e := io.CopyBuffer(h, io.LimitReader(r, length), buffer); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// hashOggAudio hashes the bodies of the first audio pages in `r`, at least
+// `window` bytes of them, and the granule position of the last. Comments are in
+// header pages, and changing them can change the number of pages, and so the
+// page sequence numbers and checksums, so page headers are left out.
+func hashOggAudio(h hash.Hash, r io.ReadSeeker, size, window int64) error {
+	var serial uint32
+	hashed := int64(0)
+	for offset := int64(0); offset < size && hashed < window; {
+		page, e := readOggPage(r, offset)
+		if e != nil {
+			return e
+		}
+		serial = page.serial
+		// Header pages all have a granule position of 0.
+		if page.granule != 0 {
+			if _, e := r.Seek(page.start, io.SeekStart); e != nil {
+				return e
+			}
+			n, e := io.Copy(h, io.LimitReader(r, page.end-page.start))
+			if e != nil {
+				return e
+			}
+			hashed += n
+		}
+		offset = page.end
+	}
+	if hashed == 0 {
+		return errNoAudioStream
+	}
+	granule, e := getLastOggGranule(r, size, serial)
+	if e != nil {
+		return e
+	}
+	return binary.Write(h, binary.BigEndian, granule)
+}
+
+// readItemID returns an ID for the audio in `r`, which is the file at
+// `pathname` and is `size` bytes long. The ID is a hash of the audio payload,
+// without the tags, so it stays the same when the file is renamed, moved, or
+// retagged. (Files with identical audio have the same hash, so
+// `assignUniqueIDs` tells them apart.)
+//
+// To keep scans fast, only the length and some parts of large payloads are
+// hashed.
+func readItemID(pathname string, r io.ReadSeeker, size int64) (string, error) {
+	return hashAudio(pathname, r, size, itemIDWindowSize)
+}
+
+// readAudioHash is like `readItemID`, but hashes all of the audio payload, so
+// that it tells apart files whose audio differs anywhere. It is slow.
+func readAudioHash(pathname string, r io.ReadSeeker, size int64) (string, error) {
+	return hashAudio(pathname, r, size, size)
+}
+
+// hashAudio returns a hash of the audio in `r`, hashing parts of it that are
+// `window` bytes long. See `readItemID`.
+func hashAudio(pathname string, r io.ReadSeeker, size, window int64) (string, error) {
+	h := sha256.New()
+	var e error
+	switch getBasenameExtension(pathname) {
+	case ".ogg", ".oga", ".opus":
+		e = hashOggAudio(h, r, size, window)
+	default:
+		var start, end int64
+		start, end, e = getAudioPayload(pathname, r, size)
+		if e == nil && start > end {
+			e = errNoAudioStream
+		}
+		if e == nil {
+			e = hashWindows(h, r, start, end, window)
+		}
+	}
+	if e == errNoAudioStream || e == io.EOF || e == io.ErrUnexpectedEOF {
+		// Not what it says it is, so just hash the whole file.
+		h.Reset()
+		e = hashWindows(h, r, 0, size, window)
+	}
+	if e != nil {
+		return "", e
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// getAudioHash returns the hash of the audio that `id` was made from, without
+// any suffix that `assignUniqueIDs` added.
+func getAudioHash(id string) string {
+	if i := strings.IndexByte(id, '-'); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// assignUniqueIDs makes the IDs of the items in `c` unique. Items with the same
+// audio as an earlier item get a suffix: "-2", "-3", and so on. Items that are
+// in `previous` at the same pathname keep their IDs, so that links to them keep
+// working when a copy turns up earlier in the walk.
+func (c *Catalog) assignUniqueIDs(previous *Catalog) {
+	previousIDs := previous.byID()
+	taken := map[string]bool{}
+	kept := map[int]bool{}
+	for i := range c.ItemInfos {
+		item := &c.ItemInfos[i]
+		if p, ok := previousIDs[item.ID]; ok && p.Pathname == item.Pathname && !taken[item.ID] {
+			taken[item.ID] = true
+			kept[i] = true
+		}
+	}
+	for i := range c.ItemInfos {
+		item := &c.ItemInfos[i]
+		if kept[i] || item.ID == "" {
+			continue
+		}
+		if taken[item.ID] {
+			hash := getAudioHash(item.ID)
+			n := 2
+			for taken[fmt.Sprintf("%s-%d", hash, n)] {
+				n++
+			}
+			item.ID = fmt.Sprintf("%s-%d", hash, n)
+		}
+		taken[item.ID] = true
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func getTestItemID(t *testing.T, pathname string, data []byte) string {
+	t.Helper()
+	id, e := readItemID(pathname, bytes.NewReader(data), int64(len(data)))
+	if e != nil {
+		t.Fatalf("%s: %v", pathname, e)
+	}
+	return id
+}
+
+func TestReadItemIDIgnoresTags(t *testing.T) {
+	audio := makeMP3(1000, nil)
+	short := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x0a"), make([]byte, 10)...)
+	long := append([]byte("ID3\x04\x00\x00\x00\x00\x01\x00"), make([]byte, 128)...)
+	v1 := append([]byte("TAG"), make([]byte, 125)...)
+	enhanced := append([]byte("TAG+"), make([]byte, 223)...)
+
+	id := getTestItemID(t, "a.mp3", audio)
+	for i, data := range [][]byte{
+		append(append([]byte{}, short...), audio...),
+		append(append([]byte{}, long...), audio...),
+		append(append(append([]byte{}, short...), audio...), v1...),
+		append(append(append(append([]byte{}, short...), audio...), enhanced...), v1...),
+	} {
+		if got := getTestItemID(t, "a.mp3", data); got != id {
+			t.Errorf("%d: expected %q, got %q", i, id, got)
+		}
+	}
+
+	changed := append([]byte{}, audio...)
+	changed[len(changed)/2] ^= 1
+	if getTestItemID(t, "a.mp3", changed) == id {
+		t.Error("changing the audio did not change the ID")
+	}
+	if getTestItemID(t, "a.mp3", audio[:len(audio)-417]) == id {
+		t.Error("shortening the audio did not change the ID")
+	}
+
+	var identification bytes.Buffer
+	identification.WriteString("\x01vorbis\x00\x00\x00\x00\x01\x44\xac\x00\x00")
+	ogg := func(comments int) []byte {
+		data := makeOggPage(2, 0, identification.Bytes())
+		for i := 0; i < comments; i++ {
+			data = append(data, makeOggPage(0, 0, []byte("\x03vorbis comment"))...)
+		}
+		data = append(data, makeOggPage(0, 44100, bytes.Repeat([]byte{1}, 200))...)
+		return append(data, makeOggPage(4, 44100*5, bytes.Repeat([]byte{2}, 200))...)
+	}
+	if getTestItemID(t, "a.ogg", ogg(1)) != getTestItemID(t, "a.ogg", ogg(3)) {
+		t.Error("changing Ogg comments changed the ID")
+	}
+
+	if getTestItemID(t, "junk.mp3", []byte("junk")) == getTestItemID(t, "junk.mp3", []byte("more junk")) {
+		t.Error("different junk files have the same ID")
+	}
+}
+
+func TestNewCatalogFollowsMovedItems(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	writeTestFile(t, path.Join(root, "Artist/Album/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(root, "Artist/Album/02 Two.mp3"), "two")
+
+	previous, _ := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 2})
+	ids := map[string]string{}
+	for i := range previous.ItemInfos {
+		previous.ItemInfos[i].Added = "2001-02-03"
+		ids[path.Base(previous.ItemInfos[i].Pathname)] = previous.ItemInfos[i].ID
+	}
+	if ids["01%20One.mp3"] == "" || ids["01%20One.mp3"] == ids["02%20Two.mp3"] {
+		t.Fatalf("expected distinct IDs, got %q", ids)
+	}
+
+	if e := os.Rename(path.Join(root, "Artist/Album"), path.Join(root, "Artist/Renamed")); e != nil {
+		t.Fatal(e)
+	}
+	c, report := newCatalog(logger, musicRoots{{Pathname: root}}, previous, scanOptions{Workers: 2})
+	if report.Moved != 2 {
+		t.Errorf("expected 2 moved items, got %d", report.Moved)
+	}
+	for _, item := range c.ItemInfos {
+		if item.ID != ids[path.Base(item.Pathname)] {
+			t.Errorf("%q: expected ID %q, got %q", item.Pathname, ids[path.Base(item.Pathname)], item.ID)
+		}
+		if item.Added != "2001-02-03" {
+			t.Errorf("%q: expected added 2001-02-03, got %q", item.Pathname, item.Added)
+		}
+	}
+
+	h := &httpHandler{Roots: musicRoots{{Pathname: root}}, Catalog: newLiveCatalog(c), Logger: logger}
+	for id, expected := range map[string]int{ids["02%20Two.mp3"]: http.StatusOK, "nonexistent": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		h.serveTrack(w, httptest.NewRequest("GET", "/track/"+id, nil))
+		if w.Code != expected {
+			t.Errorf("%q: expected status %d, got %d", id, expected, w.Code)
+		} else if expected == http.StatusOK && w.Body.String() != "two" {
+			t.Errorf("%q: expected %q, got %q", id, "two", w.Body.String())
+		}
+	}
+}
+
+func TestCopiesGetUniqueIDs(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	roots := musicRoots{{Pathname: root}}
+	writeTestFile(t, path.Join(root, "Artist/Album/01 One.mp3"), "one")
+
+	previous, _ := newCatalog(logger, roots, nil, scanOptions{Workers: 1})
+	previous.ItemInfos[0].Added = "2001-02-03"
+	id := previous.ItemInfos[0].ID
+
+	// A copy that comes first in the walk is new, and does not take the
+	// original's ID.
+	writeTestFile(t, path.Join(root, "A Copy/01 One.mp3"), "one")
+	c, report := newCatalog(logger, roots, previous, scanOptions{Workers: 2})
+	if report.Moved != 0 || len(c.ItemInfos) != 2 {
+		t.Fatalf("expected 2 items and no moves, got %+v", report)
+	}
+	duplicate, original := c.ItemInfos[0], c.ItemInfos[1]
+	if original.ID != id || duplicate.ID != id+"-2" {
+		t.Errorf("expected IDs %q and %q, got %q and %q", id, id+"-2", original.ID, duplicate.ID)
+	}
+	if original.Added != "2001-02-03" || duplicate.Added == "2001-02-03" {
+		t.Errorf("expected only the original to keep its added date, got %q and %q", original.Added, duplicate.Added)
+	}
+
+	// The same goes for copies the watcher sees.
+	writeTestFile(t, path.Join(root, "B Copy/01 One.mp3"), "one")
+	u := c.withChanges(logger, roots, scanOptions{}, newTreeWalker(roots, false), []string{path.Join(root, "B Copy")})
+	ids := map[string]bool{}
+	for _, item := range u.ItemInfos {
+		ids[item.ID] = true
+		if item.Pathname == "B%20Copy/01%20One.mp3" && (item.ID != id+"-3" || item.Added == "2001-02-03") {
+			t.Errorf("unexpected watched copy %+v", item)
+		}
+	}
+	if len(ids) != 3 {
+		t.Errorf("expected 3 distinct IDs, got %v", ids)
+	}
+}
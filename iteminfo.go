@@ -6,34 +6,68 @@ package main
 import (
 	"id3"
 	"net/url"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 type ItemInfo struct {
-	Pathname           string    `json:"pathname"`
-	Album              string    `json:"album"`
-	Artist             string    `json:"artist"`
-	Name               string    `json:"name"`
-	Disc               string    `json:"disc"`
-	Track              string    `json:"track"`
-	Year               string    `json:"year"`
-	Genre              string    `json:"genre"`
-	NormalizedPathname string    `json:"-"`
-	NormalizedAlbum    string    `json:"-"`
-	NormalizedArtist   string    `json:"-"`
-	NormalizedName     string    `json:"-"`
-	NormalizedDisc     string    `json:"-"`
-	NormalizedTrack    string    `json:"-"`
-	NormalizedYear     string    `json:"-"`
-	NormalizedGenre    string    `json:"-"`
-	ModTime            string    `json:"-"`
-	File               *id3.File `json:"-"`
+	Pathname           string `json:"pathname"`
+	ID                 string `json:"id"`
+	Album              string `json:"album"`
+	Artist             string `json:"artist"`
+	Name               string `json:"name"`
+	Disc               string `json:"disc"`
+	Track              string `json:"track"`
+	Year               string `json:"year"`
+	Genre              string `json:"genre"`
+	NormalizedPathname string `json:"-"`
+	NormalizedAlbum    string `json:"-"`
+	NormalizedArtist   string `json:"-"`
+	NormalizedName     string `json:"-"`
+	NormalizedDisc     string `json:"-"`
+	NormalizedTrack    string `json:"-"`
+	NormalizedYear     string `json:"-"`
+	NormalizedGenre    string `json:"-"`
+	Root               string `json:"root,omitempty"`
+	ModTime            string `json:"-"`
+	// The date the item was first cataloged, in the same format as `ModTime`.
+	Added       string    `json:"-"`
+	FileModTime time.Time `json:"-"`
+	Size        int64     `json:"size"`
+	Tags        *Metadata `json:"-"`
+	// Tags as stored by catalogs before version 9. The migration to version 9
+	// converts them to `Tags`.
+	File *id3.File `json:"-"`
+	// Set for items from catalogs before version 3, which had no
+	// `AudioProperties`. The next scan reads them without re-reading the tags.
+	MissingProperties bool `json:"-"`
+	AudioProperties
 }
 
 type ItemInfos []ItemInfo
 
+// isCurrent returns true if `i` was made from a file with the same size and
+// modification time as `info`, i.e. if there is no need to re-read the file.
+func (i *ItemInfo) isCurrent(info os.FileInfo) bool {
+	return i.Size == info.Size() && i.FileModTime.Equal(info.ModTime())
+}
+
+// carryOver copies to `i` what it should keep from `previous`, an earlier
+// version of the same item. (It may have had a different pathname, if the file
+// was renamed or moved.)
+func (i *ItemInfo) carryOver(previous *ItemInfo) {
+	if previous.Added != "" {
+		i.Added = previous.Added
+	}
+	// Keep the suffix, if any, that `assignUniqueIDs` gave the previous ID.
+	if previous.ID != "" && getAudioHash(previous.ID) == getAudioHash(i.ID) {
+		i.ID = previous.ID
+	}
+}
+
 // This terrible hack is an alternative to separately `url.PathEscape`ing each
 // pathname component and then re-joining them. That would be conceptually
 // better but this is expedient.
@@ -42,6 +76,14 @@ func pathnameEscape(pathname string) string {
 	return strings.ReplaceAll(url.PathEscape(pathname), "%2F", "/")
 }
 
+// unescapePathname reverses `pathnameEscape`.
+func unescapePathname(pathname string) string {
+	if unescaped, e := url.PathUnescape(pathname); e == nil {
+		return unescaped
+	}
+	return pathname
+}
+
 var (
 	discTrackAndNameMatcher = regexp.MustCompile(`^\s*(\d*)?-?(\d*)?\s+(.*)$`)
 )
@@ -62,7 +104,11 @@ func getDiscTrackAndNameFromBasename(basename string) (string, string, string) {
 //	".../AC_DC/Back In Black/1-01 Hells Bells.m4a"
 //	     performer/album/disc#-track# name
 func (i *ItemInfo) fillMetadataFromPathname() {
-	parts := strings.Split(i.Pathname, string(filepath.Separator))
+	pathname := i.Pathname
+	if i.Root != "" {
+		pathname = strings.TrimPrefix(pathname, i.Root+"/")
+	}
+	parts := strings.Split(pathname, string(filepath.Separator))
 	length := len(parts)
 	if length > 2 {
 		i.Artist = parts[length-3]
@@ -79,34 +125,34 @@ func (i *ItemInfo) fillMetadataFromPathname() {
 func (i *ItemInfo) fillMetadata() {
 	i.fillMetadataFromPathname()
 
-	if i.File != nil {
-		i.File.Album = strings.TrimSpace(i.File.Album)
-		if i.File.Album != "" {
-			i.Album = i.File.Album
+	if i.Tags != nil {
+		i.Tags.Album = strings.TrimSpace(i.Tags.Album)
+		if i.Tags.Album != "" {
+			i.Album = i.Tags.Album
 		}
-		i.File.Artist = strings.TrimSpace(i.File.Artist)
-		if i.File.Artist != "" {
-			i.Artist = i.File.Artist
+		i.Tags.Artist = strings.TrimSpace(i.Tags.Artist)
+		if i.Tags.Artist != "" {
+			i.Artist = i.Tags.Artist
 		}
-		i.File.Name = strings.TrimSpace(i.File.Name)
-		if i.File.Name != "" {
-			i.Name = i.File.Name
+		i.Tags.Name = strings.TrimSpace(i.Tags.Name)
+		if i.Tags.Name != "" {
+			i.Name = i.Tags.Name
 		}
-		i.File.Disc = strings.TrimSpace(i.File.Disc)
-		if i.File.Disc != "" {
-			i.Disc = i.File.Disc
+		i.Tags.Disc = strings.TrimSpace(i.Tags.Disc)
+		if i.Tags.Disc != "" {
+			i.Disc = i.Tags.Disc
 		}
-		i.File.Track = strings.TrimSpace(i.File.Track)
-		if i.File.Track != "" {
-			i.Track = i.File.Track
+		i.Tags.Track = strings.TrimSpace(i.Tags.Track)
+		if i.Tags.Track != "" {
+			i.Track = i.Tags.Track
 		}
-		i.File.Year = strings.TrimSpace(i.File.Year)
-		if i.File.Year != "" {
-			i.Year = i.File.Year
+		i.Tags.Year = strings.TrimSpace(i.Tags.Year)
+		if i.Tags.Year != "" {
+			i.Year = i.Tags.Year
 		}
-		i.File.Genre = strings.TrimSpace(i.File.Genre)
-		if i.File.Genre != "" {
-			i.Genre = i.File.Genre
+		i.Tags.Genre = strings.TrimSpace(i.Tags.Genre)
+		if i.Tags.Genre != "" {
+			i.Genre = i.Tags.Genre
 		}
 	}
 
@@ -0,0 +1,244 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+	lintInfo    = "info"
+)
+
+// A lintProblem is a metadata problem in an album. `Pathname` is set if the
+// problem is with a particular item.
+type lintProblem struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Pathname string `json:"pathname,omitempty"`
+	Message  string `json:"message"`
+}
+
+type albumLint struct {
+	Directory string        `json:"directory"`
+	Problems  []lintProblem `json:"problems"`
+}
+
+// groupItemsByDirectory returns the directories of `items` in the order they
+// first appear, and a map from each directory to its items.
+func groupItemsByDirectory(items ItemInfos) ([]string, map[string]ItemInfos) {
+	var dirs []string
+	byDir := map[string]ItemInfos{}
+	for _, item := range items {
+		dir := path.Dir(item.Pathname)
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], item)
+	}
+	return dirs, byDir
+}
+
+// getDistinctValues returns the distinct, non-empty values of `field` in
+// `items`, in order of appearance.
+func getDistinctValues(items ItemInfos, field func(*ItemInfo) string) []string {
+	var values []string
+	seen := map[string]bool{}
+	for i := range items {
+		v := field(&items[i])
+		if v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func lintMissingTags(items ItemInfos) []lintProblem {
+	var problems []lintProblem
+	for _, item := range items {
+		if item.Tags == nil {
+			problems = append(problems, lintProblem{lintWarning, "missing-tags", item.Pathname, "no tags; metadata comes from the pathname"})
+			continue
+		}
+		var missing []string
+		for _, f := range []struct {
+			name  string
+			value string
+		}{
+			{"name", item.Tags.Name},
+			{"artist", item.Tags.Artist},
+			{"album", item.Tags.Album},
+			{"track", item.Tags.Track},
+		} {
+			if f.value == "" {
+				missing = append(missing, f.name)
+			}
+		}
+		if len(missing) > 0 {
+			problems = append(problems, lintProblem{lintWarning, "missing-tags", item.Pathname, "no " + strings.Join(missing, ", ") + " tag"})
+		}
+	}
+	return problems
+}
+
+func lintInconsistentValues(items ItemInfos) []lintProblem {
+	var problems []lintProblem
+	for _, f := range []struct {
+		name     string
+		severity string
+		value    func(*ItemInfo) string
+	}{
+		{"album", lintWarning, func(i *ItemInfo) string { return i.Album }},
+		// Compilations legitimately have many artists.
+		{"artist", lintInfo, func(i *ItemInfo) string { return i.Artist }},
+		{"year", lintInfo, func(i *ItemInfo) string { return i.Year }},
+	} {
+		if values := getDistinctValues(items, f.value); len(values) > 1 {
+			problems = append(problems, lintProblem{f.severity, "inconsistent-" + f.name, "", fmt.Sprintf("%d different %s values: %q", len(values), f.name, values)})
+		}
+	}
+	return problems
+}
+
+func lintTrackNumbers(items ItemInfos) []lintProblem {
+	var problems []lintProblem
+	tracksByDisc := map[string]map[int]string{}
+	for _, item := range items {
+		track, e := strconv.Atoi(item.NormalizedTrack)
+		if e != nil {
+			problems = append(problems, lintProblem{lintWarning, "missing-track-number", item.Pathname, "no track number"})
+			continue
+		}
+		tracks, ok := tracksByDisc[item.NormalizedDisc]
+		if !ok {
+			tracks = map[int]string{}
+			tracksByDisc[item.NormalizedDisc] = tracks
+		}
+		if other, ok := tracks[track]; ok {
+			problems = append(problems, lintProblem{lintError, "duplicate-track-number", item.Pathname, fmt.Sprintf("track %d is also %s", track, unescapePathname(path.Base(other)))})
+			continue
+		}
+		tracks[track] = item.Pathname
+	}
+
+	var discs []string
+	for disc := range tracksByDisc {
+		discs = append(discs, disc)
+	}
+	sort.Strings(discs)
+	for _, disc := range discs {
+		tracks := tracksByDisc[disc]
+		highest := 0
+		for track := range tracks {
+			if track > highest {
+				highest = track
+			}
+		}
+		var missing []string
+		for track := 1; track < highest; track++ {
+			if _, ok := tracks[track]; !ok {
+				missing = append(missing, strconv.Itoa(track))
+			}
+		}
+		if len(missing) > 0 {
+			where := ""
+			if disc != "" {
+				where = " on disc " + disc
+			}
+			problems = append(problems, lintProblem{lintWarning, "track-number-gap", "", fmt.Sprintf("missing track %s%s", strings.Join(missing, ", "), where)})
+		}
+	}
+	return problems
+}
+
+func lintCover(roots musicRoots, dir string) []lintProblem {
+	pathname, _ := roots.resolve(unescapePathname(dir))
+	for _, extension := range coverExtensions {
+		if _, e := os.Stat(filepath.Join(pathname, "cover"+extension)); e == nil {
+			return nil
+		}
+	}
+	return []lintProblem{{lintWarning, "missing-cover", "", "no cover image"}}
+}
+
+func lintPermissions(roots musicRoots, items ItemInfos) []lintProblem {
+	var problems []lintProblem
+	for i := range items {
+		info, e := os.Stat(roots.getItemFilePathname(&items[i]))
+		if e != nil {
+			problems = append(problems, lintProblem{lintError, "missing-file", items[i].Pathname, e.Error()})
+		} else if !isFileWorldReadable(info) {
+			problems = append(problems, lintProblem{lintError, "not-world-readable", items[i].Pathname, "not world-readable, so the server will not serve it"})
+		}
+	}
+	return problems
+}
+
+// lintCatalog checks the albums in `c`, whose files are in `roots`, for
+// metadata problems. It returns only the albums that have problems.
+func lintCatalog(c *Catalog, roots musicRoots) []albumLint {
+	results := []albumLint{}
+	dirs, byDir := groupItemsByDirectory(c.ItemInfos)
+	for _, dir := range dirs {
+		items := byDir[dir]
+		var problems []lintProblem
+		problems = append(problems, lintMissingTags(items)...)
+		problems = append(problems, lintInconsistentValues(items)...)
+		problems = append(problems, lintTrackNumbers(items)...)
+		problems = append(problems, lintCover(roots, dir)...)
+		problems = append(problems, lintPermissions(roots, items)...)
+		if len(problems) > 0 {
+			results = append(results, albumLint{dir, problems})
+		}
+	}
+	return results
+}
+
+func writeLintText(w io.Writer, albums []albumLint) error {
+	counts := map[string]int{}
+	total := 0
+	for _, a := range albums {
+		fmt.Fprintf(w, "%s\n", unescapePathname(a.Directory))
+		for _, p := range a.Problems {
+			where := ""
+			if p.Pathname != "" {
+				where = unescapePathname(path.Base(p.Pathname)) + ": "
+			}
+			fmt.Fprintf(w, "  %-7s  %-22s  %s%s\n", p.Severity, p.Check, where, p.Message)
+			counts[p.Severity]++
+			total++
+		}
+	}
+	_, e := fmt.Fprintf(w, "%d problems in %d albums: %d errors, %d warnings, %d info\n", total, len(albums), counts[lintError], counts[lintWarning], counts[lintInfo])
+	return e
+}
+
+// writeLint writes the problems in `c` to `w` in the given `format`: "text" or
+// "json".
+func writeLint(w io.Writer, c *Catalog, roots musicRoots, format string) error {
+	albums := lintCatalog(c, roots)
+	switch format {
+	case "", "text":
+		return writeLintText(w, albums)
+	case "json":
+		data, e := json.MarshalIndent(albums, "", "  ")
+		if e != nil {
+			return e
+		}
+		_, e = w.Write(append(data, '\n'))
+		return e
+	}
+	return fmt.Errorf("unknown lint format %q", format)
+}
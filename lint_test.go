@@ -0,0 +1,55 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLintCatalog(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, path.Join(root, "Artist/Album/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(root, "Artist/Album/01 Again.mp3"), "again")
+	writeTestFile(t, path.Join(root, "Artist/Album/04 Four.mp3"), "four")
+	if e := os.Chmod(path.Join(root, "Artist/Album/04 Four.mp3"), 0600); e != nil {
+		t.Fatal(e)
+	}
+	writeTestFile(t, path.Join(root, "Artist/Good/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(root, "Artist/Good/cover.jpg"), "cover")
+
+	tags := &Metadata{Name: "One", Artist: "Artist", Album: "Good", Track: "1"}
+	c := &Catalog{ItemInfos: ItemInfos{
+		{Pathname: "Artist/Album/01 One.mp3"},
+		{Pathname: "Artist/Album/01 Again.mp3", Tags: &Metadata{Album: "Albun"}},
+		{Pathname: "Artist/Album/04 Four.mp3"},
+		{Pathname: "Artist/Good/01 One.mp3", Tags: tags},
+	}}
+	for i := range c.ItemInfos {
+		c.ItemInfos[i].fillMetadata()
+	}
+
+	albums := lintCatalog(c, musicRoots{{Pathname: root}})
+	if len(albums) != 1 || albums[0].Directory != "Artist/Album" {
+		t.Fatalf("expected problems only in Artist/Album, got %v", albums)
+	}
+	checks := map[string]int{}
+	for _, p := range albums[0].Problems {
+		checks[p.Check]++
+	}
+	expected := map[string]int{
+		"missing-tags":           3,
+		"inconsistent-album":     1,
+		"duplicate-track-number": 1,
+		"track-number-gap":       1,
+		"missing-cover":          1,
+		"not-world-readable":     1,
+	}
+	for check, n := range expected {
+		if checks[check] != n {
+			t.Errorf("%s: expected %d, got %d", check, n, checks[check])
+		}
+	}
+}
@@ -12,9 +12,11 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"regexp"
-	"strings"
+	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
@@ -115,8 +117,25 @@ func makeConfigurationDirectory(configurationPathname string) {
 	}
 }
 
+// reloadCatalogOnHangup re-reads the catalog at `catalogPathname` into `live`
+// whenever the process receives SIGHUP.
+func reloadCatalogOnHangup(log *log.Logger, catalogPathname string, live *liveCatalog) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			c, e := live.reload(catalogPathname)
+			if e != nil {
+				log.Print(e)
+				continue
+			}
+			log.Printf("Reloaded catalog with %d items", len(c.ItemInfos))
+		}
+	}()
+}
+
 // `port` is a string (not an integer) of the form ":1234".
-func serveApp(root, port, configurationPathname string, c *Catalog) {
+func serveApp(roots musicRoots, port, configurationPathname, catalogPathname string, c *liveCatalog) {
 	addresses, e := net.InterfaceAddrs()
 	if e != nil || len(addresses) == 0 {
 		log.Fatal(e)
@@ -148,7 +167,7 @@ func serveApp(root, port, configurationPathname string, c *Catalog) {
 		}
 	}
 
-	handler := httpHandler{Root: root, ConfigurationPathname: configurationPathname, Catalog: c, Logger: log.Default()}
+	handler := httpHandler{Roots: roots, ConfigurationPathname: configurationPathname, CatalogPathname: catalogPathname, Catalog: c, Logger: log.Default()}
 
 	minifier := minify.New()
 	minifier.AddFunc("text/css", css.Minify)
@@ -167,24 +186,144 @@ func serveApp(root, port, configurationPathname string, c *Catalog) {
 func printHelp() {
 	fmt.Println(`Usage:
 
-  bean-machine -m music-directory serve
+  bean-machine -m music-directory [-j workers] [-L] catalog
+  bean-machine [-format text|json] [-o file] catalog-diff old-catalog new-catalog
+  bean-machine -m music-directory [-format json|csv|m3u8] [-q query] [-o file] export
+  bean-machine -m music-directory [-format text|json|plan] [-o file] duplicates
+  bean-machine -m music-directory [-format text|json] [-o file] lint
+  bean-machine -m music-directory [-w] [-L] serve
   bean-machine set-password
 
+To catalog and serve several music directories together, give -m more than
+once, as -m name=music-directory. Each directory is served under /name/, and
+the search keyword root:name finds items in only that one. Without -m, the
+music directories are read from ~/.bean-machine/roots, which has one
+name=music-directory per line. The catalog of a single, unnamed music directory
+is stored in that directory; otherwise, it is stored in ~/.bean-machine.
+
 Here is what the commands do:
 
-  serve
+  catalog
     Scans music-directory for music files, and creates a database of their
-    metadata.
+    metadata. If there is already a database, only new and changed files are
+    read again. Up to workers files (by default, the number of CPUs) are read
+    at the same time. Files that cannot be read are listed at the end, and in
+    catalog-report.json next to the catalog. The previous catalog is kept as a
+    backup, which serve uses if the current catalog is damaged.
+
+    Metadata comes from ID3 tags, FLAC, Ogg Vorbis, and Opus comments, and
+    MP4 (iTunes) tags. The format is recognized from the start of the file, so
+    misnamed files are read too. Where there are no tags, metadata is guessed
+    from the pathname, as artist/album/disc-track name.
+
+    A catalog written by an older version of Bean Machine is upgraded when it
+    is read. Usually, the next catalog command then reads again only the
+    files in formats that this version reads more from. But if the catalog is
+    from before ID3 unsynchronisation and compressed frames were understood,
+    every file with ID3 tags or no tags is read again, which for most
+    libraries is nearly a full scan.
+
+    Each item gets an ID, which is a hash of its audio without the tags. The
+    ID stays the same when the file is renamed, moved, or retagged, so the
+    catalog remembers when the item was added, and the summary counts it as
+    moved. A copy of a file that is still there is a new item: copies get
+    their own IDs, with a suffix such as -2.
+
+    Files and directories that match the rules in a .beanignore file are not
+    cataloged. The rules are in .gitignore syntax, and apply to the directory
+    the .beanignore file is in and those beneath it. Rules in
+    ~/.bean-machine/ignore apply to every music directory. The summary and
+    the report count what each rule excluded.
+
+    Symbolic links are skipped, unless -L is given. Then, links to files and
+    directories inside the music directories are followed, and each target is
+    cataloged only once, under the first pathname that leads to it. Links
+    that lead outside the music directories, or into a loop, are reported as
+    errors. Either way, the server refuses to serve files that are outside
+    the music directories once links are followed.
+
+  catalog-diff
+    Compares two catalog files, and lists the items that were added, removed,
+    or modified between them. For modified items, it lists the fields that
+    changed. Fields that were empty in the first catalog, such as those that
+    older versions did not record, are not compared. The output is text (the
+    default) or JSON.
+
+  duplicates
+    Lists items and albums that are duplicates of each other, at 3 levels:
+    identical items have the same audio, ignoring their tags, as confirmed
+    by reading their files in full, which can be slow; same-track
+    items have the same artist, album, disc, and track number, but different
+    audio, such as the same track in different formats; and similar albums
+    share at least 80% of their track names. The first of each group is the
+    one to keep: the item with the highest bitrate, or the album with the
+    most distinct tracks and then the highest bitrate. The output is text
+    (the default), JSON, or, with -format plan, a shell script that would
+    remove the rest. The script keeps any tracks of a similar album whose
+    names are not in the album being kept, and lists them in comments.
+    Nothing is removed unless you run that script.
+
+  export
+    Writes the catalog to standard output, or to file, as JSON (the default),
+    CSV, or an M3U8 playlist. If query is given, only the items that match it
+    are exported. The tags as read from each file are the tags.* fields, and
+    tags.format says what kind of tags they were.
+
+  lint
+    Checks each album directory in the catalog for metadata problems, such as
+    missing tags, inconsistent album or artist names, duplicate or missing
+    track numbers, a missing cover image, and files that are not
+    world-readable (and which the server will therefore refuse to serve). Each
+    problem is rated as an error, a warning, or info. The output is text (the
+    default) or JSON.
 
+  serve
     Starts a web server rooted at music-directory, and prints out the URL(s)
     of the Bean Machine web app.
 
+    Albums without a cover image file are shown with the cover embedded in
+    their first FLAC, Ogg, Opus, or MP4 file that has one.
+
+    Each item is also served at /track/id, which keeps working when the file
+    is renamed or moved.
+
+    The catalog also groups items into albums, one per directory, and artists.
+    /albums lists the albums (only those with items matching query, given
+    ?q=query), and /albums/directory gives one album and its items. /artists
+    and /artists/name do the same for artists.
+
+    The server re-reads the catalog when it receives SIGHUP, or when a
+    logged-in user POSTs to /admin/reload. There are no separate
+    administrators: every logged-in user can reload. Reloading only
+    re-reads the catalog file; it never changes it.
+
+    With -w, the server watches music-directory for changes, and updates the
+    catalog as files are added, changed, and removed. (This is supported only
+    on Linux.) Give -L here too, if you gave it to catalog.
+
   set-password
     Prompts for a username and password, and sets the password for the given
     username.`)
 	os.Exit(1)
 }
 
+// withOutputFile calls `f` with the file at `pathname`, or with standard output
+// if `pathname` is empty.
+func withOutputFile(pathname string, f func(io.Writer) error) error {
+	if pathname == "" {
+		return f(os.Stdout)
+	}
+	w, e := os.Create(pathname)
+	if e != nil {
+		return e
+	}
+	if e := f(w); e != nil {
+		_ = w.Close()
+		return e
+	}
+	return w.Close()
+}
+
 func assertDirectory(pathname string) {
 	info, e := os.Stat(pathname)
 	if e != nil {
@@ -195,17 +334,32 @@ func assertDirectory(pathname string) {
 	}
 }
 
+// assertMusicRoots exits if `roots` are not valid, existing directories.
+func assertMusicRoots(roots musicRoots) {
+	if e := roots.validate(); e != nil {
+		log.Fatal(e)
+	}
+	for _, r := range roots {
+		assertDirectory(r.Pathname)
+	}
+}
+
 func main() {
 	log.SetFlags(log.Ldate | log.LUTC | log.Ltime | log.Lmicroseconds | log.Lshortfile)
 
 	needsHelp1 := flag.Bool("help", false, "Print the help message.")
 	needsHelp2 := flag.Bool("h", false, "Print the help message.")
-	rawRoot := flag.String("m", "", "Set the music directory.")
+	var roots musicRoots
+	flag.Var(&roots, "m", "Set a music directory, as pathname or name=pathname. Give -m more than once to serve several.")
 	port := flag.Int("p", 0, "Set the port the server listens on.")
+	format := flag.String("format", "", "Set the output format of export (json, csv, or m3u8), of catalog-diff or lint (text or json), or of duplicates (text, json, or plan).")
+	query := flag.String("q", "", "Export only the items matching this search query.")
+	output := flag.String("o", "", "Set the output file of export, catalog-diff, duplicates, or lint (default: standard output).")
+	watch := flag.Bool("w", false, "Watch the music directory for changes while serving.")
+	workers := flag.Int("j", runtime.NumCPU(), "Set the number of files to read concurrently while cataloging.")
+	followSymlinks := flag.Bool("L", false, "Follow symbolic links while cataloging.")
 	flag.Parse()
 
-	root := strings.TrimRight(*rawRoot, string(os.PathSeparator))
-
 	portString := ":1234"
 	if *port > 0 && *port < 65536 {
 		portString = fmt.Sprintf(":%d", *port)
@@ -213,6 +367,10 @@ func main() {
 		log.Fatal("The port number must be in the range 1 – 65535.")
 	}
 
+	if *workers < 1 {
+		log.Fatal("The number of concurrent reads must be at least 1.")
+	}
+
 	if *needsHelp1 || *needsHelp2 || flag.NArg() == 0 {
 		printHelp()
 	}
@@ -220,28 +378,103 @@ func main() {
 	configurationPathname := path.Join(getHomePathname(), configurationBasename)
 	makeConfigurationDirectory(configurationPathname)
 
+	if len(roots) == 0 {
+		var e error
+		roots, e = readMusicRootsFile(path.Join(configurationPathname, rootsBasename))
+		if e != nil && !os.IsNotExist(e) {
+			log.Fatal(e)
+		}
+	}
+	catalogDirectory := roots.getCatalogDirectory(configurationPathname)
+	catalogPathname := path.Join(catalogDirectory, catalogBasename)
+
+	options := scanOptions{Workers: *workers, FollowSymlinks: *followSymlinks}
+	if rules, e := readIgnoreFile(path.Join(configurationPathname, globalIgnoreBasename)); e == nil {
+		options.IgnoreRules = rules
+	} else if !os.IsNotExist(e) {
+		log.Fatal(e)
+	}
+
 	for i := 0; i < flag.NArg(); i++ {
 		command := flag.Arg(i)
 		switch command {
 		case "catalog":
-			assertDirectory(root)
-			c, e := newCatalog(log.Default(), root)
+			assertMusicRoots(roots)
+			previous, e := readCatalogWithFallback(log.Default(), catalogPathname)
+			if e != nil {
+				if !os.IsNotExist(e) {
+					log.Print(e)
+				}
+				previous = nil
+			}
+			c, report := newCatalog(log.Default(), roots, previous, options)
+			if e := c.writeToFile(catalogPathname, true); e != nil {
+				log.Fatal(e)
+			}
+			report.printSummary(os.Stdout)
+			if e := report.writeToFile(path.Join(catalogDirectory, scanReportBasename)); e != nil {
+				log.Fatal(e)
+			}
+		case "catalog-diff":
+			if i+2 >= flag.NArg() {
+				printHelp()
+			}
+			oldPathname, newPathname := flag.Arg(i+1), flag.Arg(i+2)
+			i += 2
+			if e := withOutputFile(*output, func(w io.Writer) error {
+				return writeCatalogDiff(w, oldPathname, newPathname, *format)
+			}); e != nil {
+				log.Fatal(e)
+			}
+		case "export":
+			assertMusicRoots(roots)
+			c, e := readCatalogWithFallback(log.Default(), catalogPathname)
 			if e != nil {
 				log.Fatal(e)
 			}
-			e = c.writeToFile(path.Join(root, catalogBasename))
+			if e := withOutputFile(*output, func(w io.Writer) error {
+				return exportCatalog(w, c, roots, *format, *query)
+			}); e != nil {
+				log.Fatal(e)
+			}
+		case "duplicates":
+			assertMusicRoots(roots)
+			c, e := readCatalogWithFallback(log.Default(), catalogPathname)
 			if e != nil {
 				log.Fatal(e)
 			}
+			if e := withOutputFile(*output, func(w io.Writer) error {
+				return writeDuplicates(w, c, roots, *format)
+			}); e != nil {
+				log.Fatal(e)
+			}
 		case "help":
 			printHelp()
+		case "lint":
+			assertMusicRoots(roots)
+			c, e := readCatalogWithFallback(log.Default(), catalogPathname)
+			if e != nil {
+				log.Fatal(e)
+			}
+			if e := withOutputFile(*output, func(w io.Writer) error {
+				return writeLint(w, c, roots, *format)
+			}); e != nil {
+				log.Fatal(e)
+			}
 		case "serve":
-			assertDirectory(root)
-			catalog, e := readCatalogFromFile(path.Join(root, catalogBasename))
+			assertMusicRoots(roots)
+			c, e := readCatalogWithFallback(log.Default(), catalogPathname)
 			if e != nil {
 				log.Fatal(e)
 			}
-			serveApp(root, portString, configurationPathname, catalog)
+			live := newLiveCatalog(c)
+			if *watch {
+				if e := watchCatalog(log.Default(), roots, options, catalogPathname, live); e != nil {
+					log.Fatal(e)
+				}
+			}
+			reloadCatalogOnHangup(log.Default(), catalogPathname, live)
+			serveApp(roots, portString, configurationPathname, catalogPathname, live)
 		case "set-password":
 			username, password := promptForCredentials(os.Stdin, os.Stdout)
 			if e := setPassword(configurationPathname, username, password); e != nil {
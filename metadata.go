@@ -0,0 +1,228 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"fmt"
+	"id3"
+	"io"
+	"slices"
+)
+
+// Metadata is the tags of an item, in whatever format they were read from.
+type Metadata struct {
+	// The tag format, such as "ID3v2.3" or "FLAC".
+	Format      string
+	Name        string
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Year        string
+	// Track and disc numbers may have totals, as in ID3: "3/12".
+	Track string
+	Disc  string
+	Genre string
+	// In milliseconds, as in the ID3 TLEN frame.
+	Length      string
+	Compilation bool
+}
+
+// An embeddedPicture is an image in a file's tags, such as its cover.
+type embeddedPicture struct {
+	// As in ID3v2 APIC frames and FLAC PICTURE blocks: 3 is the front cover.
+	Type     uint32
+	MIMEType string
+	Data     []byte
+}
+
+// The `embeddedPicture.Type` of front covers.
+const frontCoverPicture = 3
+
+// A metadataReader reads the tags of one container format. Files that the
+// reader cannot make sense of may return `errNoAudioStream`. A reader may
+// return what tags it could read along with an error.
+type metadataReader interface {
+	readTags(r io.ReadSeeker, size int64) (*Metadata, error)
+	readPictures(r io.ReadSeeker, size int64) ([]embeddedPicture, error)
+}
+
+// A container is a file format that holds audio and its tags.
+type container struct {
+	name string
+	// magic returns true if `header`, the first bytes of a file after any ID3v2
+	// tag, begin this format.
+	magic func(header []byte) bool
+	// Files that no `magic` recognizes are read by extension.
+	extensions []string
+	reader     metadataReader
+}
+
+// The number of bytes of a file that `container.magic` sees, if the file is
+// that long.
+const containerHeaderSize = 12
+
+// hasMagic returns a `container.magic` that matches `magic` at `offset`.
+func hasMagic(offset int, magic string) func([]byte) bool {
+	return func(header []byte) bool {
+		return len(header) >= offset+len(magic) && string(header[offset:offset+len(magic)]) == magic
+	}
+}
+
+// isMPEGAudio returns true if `header` begins with an MPEG audio frame sync.
+func isMPEGAudio(header []byte) bool {
+	return len(header) >= 2 && header[0] == 0xff && header[1]&0xe0 == 0xe0
+}
+
+// id3Container is for MP3 files, and any other file with ID3 tags. Files that
+// are in no other container are read as ID3, too.
+var id3Container = container{"ID3", isMPEGAudio, []string{".mp3"}, id3Reader{}}
+
+// containers are the formats that we can read tags from. To support another,
+// add its `metadataReader` here.
+var containers = []container{
+	{"FLAC", hasMagic(0, "fLaC"), []string{".flac"}, flacReader{}},
+	{"MP4", hasMagic(4, "ftyp"), []string{".m4a", ".m4v", ".mov", ".mp4"}, mp4Reader{}},
+	{"Ogg", hasMagic(0, "OggS"), []string{".ogg", ".opus"}, oggReader{}},
+	id3Container,
+}
+
+// detectContainer returns the container of `r`, which is the file at
+// `pathname`. It goes by the magic bytes at the start of the file, or after the
+// ID3v2 tag if there is one (some FLAC files have them), and then by the
+// extension.
+func detectContainer(pathname string, r io.ReadSeeker) (*container, error) {
+	var header [containerHeaderSize]byte
+	n, e := readAtMost(r, 0, header[:])
+	if e != nil {
+		return nil, e
+	}
+	tagged := n >= 10 && string(header[:3]) == "ID3"
+	if tagged {
+		offset, e := getID3v2Size(r)
+		if e != nil {
+			return nil, e
+		}
+		if n, e = readAtMost(r, offset, header[:]); e != nil {
+			return nil, e
+		}
+	}
+	for i := range containers {
+		if containers[i].magic(header[:n]) {
+			return &containers[i], nil
+		}
+	}
+	if tagged {
+		return &id3Container, nil
+	}
+	return getContainerByExtension(pathname), nil
+}
+
+// getContainerByExtension returns the container that files with the extension
+// of `pathname` are usually in, or `id3Container` if there is none.
+func getContainerByExtension(pathname string) *container {
+	extension := getBasenameExtension(pathname)
+	for i := range containers {
+		if slices.Contains(containers[i].extensions, extension) {
+			return &containers[i]
+		}
+	}
+	return &id3Container
+}
+
+// readTags parses the tags in `r`, which is the file at `pathname` and is
+// `size` bytes long. Files without tags are not an error, but truncated tags
+// are. Any tags that could be read are returned along with the error.
+func readTags(pathname string, r io.ReadSeeker, size int64) (*Metadata, error) {
+	c, e := detectContainer(pathname, r)
+	if e != nil {
+		return nil, e
+	}
+	tags, e := c.reader.readTags(r, size)
+	if e == errNoAudioStream {
+		return nil, nil
+	} else if e == io.EOF || e == io.ErrUnexpectedEOF {
+		e = fmt.Errorf("truncated %s tags", c.name)
+	}
+	if tags != nil && tags.Format == "" {
+		tags.Format = c.name
+	}
+	return tags, e
+}
+
+// readCoverPicture returns the front cover embedded in `r`, which is the file
+// at `pathname` and is `size` bytes long, or if there is none, the first
+// picture. It returns nil if there are no pictures.
+func readCoverPicture(pathname string, r io.ReadSeeker, size int64) (*embeddedPicture, error) {
+	c, e := detectContainer(pathname, r)
+	if e != nil {
+		return nil, e
+	}
+	pictures, e := c.reader.readPictures(r, size)
+	if e != nil || len(pictures) == 0 {
+		return nil, e
+	}
+	for i := range pictures {
+		if pictures[i].Type == frontCoverPicture {
+			return &pictures[i], nil
+		}
+	}
+	return &pictures[0], nil
+}
+
+// id3Reader reads ID3v2 tags, and ID3v1 tags at the end of the file.
+type id3Reader struct{}
+
+func (id3Reader) readTags(r io.ReadSeeker, size int64) (*Metadata, error) {
+	if _, e := r.Seek(0, io.SeekStart); e != nil {
+		return nil, e
+	}
+	file, e := id3.Read(r)
+	if e == id3.ErrNoTag {
+		return nil, nil
+	} else if e != nil && file == nil {
+		return nil, e
+	}
+	// A malformed ID3v2 tag still leaves any ID3v1 tag, which is better than
+	// nothing; report the error too.
+	return getID3Metadata(file), e
+}
+
+// getID3Metadata returns the tags in `file`.
+func getID3Metadata(file *id3.File) *Metadata {
+	format := "ID3v1"
+	if file.Header.Version != 0 {
+		format = fmt.Sprintf("ID3v2.%d", file.Header.Version)
+	}
+	return &Metadata{
+		Format:      format,
+		Name:        file.Name,
+		Artist:      file.Artist,
+		AlbumArtist: file.AlbumArtist,
+		Album:       file.Album,
+		Year:        file.Year,
+		Track:       file.Track,
+		Disc:        file.Disc,
+		Genre:       file.Genre,
+		Length:      file.Length,
+		Compilation: file.Compilation,
+	}
+}
+
+// getLegacyMetadata converts `file`, the tags of the item at `pathname` as
+// stored by catalogs before version 9. Before then, FLAC, MP4, and Ogg tags
+// were stored as `id3.File`s too, without an ID3v2 version, so the extension
+// is the best guess at what format they were.
+func getLegacyMetadata(pathname string, file *id3.File) *Metadata {
+	tags := getID3Metadata(file)
+	if c := getContainerByExtension(pathname); file.Header.Version == 0 && c != &id3Container {
+		tags.Format = c.name
+	}
+	return tags
+}
+
+// readPictures returns nothing, because the id3 package doesn't read APIC
+// frames.
+func (id3Reader) readPictures(r io.ReadSeeker, size int64) ([]embeddedPicture, error) {
+	return nil, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDetectContainer(t *testing.T) {
+	flac := makeFLAC(nil)
+	tagged := append([]byte("ID3\x03\x00\x00\x00\x00\x00\x04\x00\x00\x00\x00"), flac...)
+	ogg := makeOggPage(2, 0, []byte("OpusHead"))
+	mp4 := makeMP4Atom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	for i, test := range []struct {
+		pathname string
+		data     []byte
+		expected string
+	}{
+		{"a.flac", flac, "FLAC"},
+		{"a.mp3", flac, "FLAC"},
+		{"a.flac", tagged, "FLAC"},
+		{"a.m4a", ogg, "Ogg"},
+		{"a.ogg", mp4, "MP4"},
+		{"a.mp3", makeMP3(1000, nil), "ID3"},
+		{"a.flac", []byte("junk"), "FLAC"},
+		{"a.mov", []byte("\x00\x00\x00\x08wide"), "MP4"},
+		{"a.wav", []byte("RIFF\x00\x00\x00\x00WAVE"), "ID3"},
+		{"a.flac", []byte("ID3\x03\x00\x00\x00\x00\x00\x00"), "ID3"},
+		{"empty", nil, "ID3"},
+	} {
+		c, e := detectContainer(test.pathname, bytes.NewReader(test.data))
+		if e != nil || c.name != test.expected {
+			t.Errorf("%s %d: expected %s, got %+v, %v", test.pathname, i, test.expected, c, e)
+		}
+	}
+}
+
+func TestReadTagsFromID3(t *testing.T) {
+	frame := append([]byte("TIT2\x00\x00\x00\x06\x00\x00\x00"), "Title"...)
+	data := append([]byte("ID3\x03\x00\x00\x00\x00\x00"), byte(len(frame)))
+	data = append(append(data, frame...), makeMP3(1000, nil)...)
+	tags, e := readTags("misnamed.flac", bytes.NewReader(data), int64(len(data)))
+	if e != nil || tags == nil || *tags != (Metadata{Format: "ID3v2.3", Name: "Title"}) {
+		t.Errorf("expected ID3v2.3 tags, got %+v, %v", tags, e)
+	}
+}
+
+func TestReadTagsKeepsID3v1WithMalformedID3v2(t *testing.T) {
+	v1 := make([]byte, 128)
+	copy(v1, "TAGName")
+	data := append([]byte("ID3\x05\x00\x00\x00\x00\x00\x10"), makeMP3(1000, nil)...)
+	data = append(data, v1...)
+	tags, e := readTags("a.mp3", bytes.NewReader(data), int64(len(data)))
+	if e == nil || tags == nil || tags.Format != "ID3v1" || tags.Name != "Name" {
+		t.Errorf("expected ID3v1 tags and an error, got %+v, %v", tags, e)
+	}
+}
@@ -0,0 +1,626 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// AudioProperties describe an audio stream, as found in its headers rather
+// than in its tags. Zero values are unknown.
+type AudioProperties struct {
+	// In seconds.
+	Duration float64 `json:"duration,omitempty"`
+	// In bits per second, averaged over the whole stream.
+	Bitrate int `json:"bitrate,omitempty"`
+	// In Hz.
+	SampleRate int `json:"samplerate,omitempty"`
+	Channels   int `json:"channels,omitempty"`
+}
+
+// errNoAudioStream means that a reader could not make sense of a file. It
+// is not worth reporting: the file may still be playable, and its properties
+// are just unknown.
+var errNoAudioStream = errors.New("no recognizable audio stream")
+
+type audioPropertiesReader func(r io.ReadSeeker, size int64) (AudioProperties, error)
+
+var audioPropertiesReaders = map[string]audioPropertiesReader{
+	".flac": readFLACProperties,
+	".m4a":  readMP4Properties,
+	".mp3":  readMP3Properties,
+	".ogg":  readOggProperties,
+	".opus": readOggProperties,
+	".wav":  readWAVProperties,
+	".wave": readWAVProperties,
+}
+
+// readAudioProperties reads the properties of the audio stream in `r`, which
+// is the file at `pathname` and is `size` bytes long. If the format is not
+// supported, or the stream is not recognizable, the properties are unknown but
+// it is not an error.
+func readAudioProperties(pathname string, r io.ReadSeeker, size int64) (AudioProperties, error) {
+	read, ok := audioPropertiesReaders[getBasenameExtension(pathname)]
+	if !ok {
+		return AudioProperties{}, nil
+	}
+	p, e := read(r, size)
+	if e == errNoAudioStream || e == io.EOF || e == io.ErrUnexpectedEOF {
+		return AudioProperties{}, nil
+	}
+	return p, e
+}
+
+// readAt reads exactly `len(buffer)` bytes at `offset` in `r`.
+func readAt(r io.ReadSeeker, offset int64, buffer []byte) error {
+	if _, e := r.Seek(offset, io.SeekStart); e != nil {
+		return e
+	}
+	_, e := io.ReadFull(r, buffer)
+	return e
+}
+
+// readAtMost reads up to `len(buffer)` bytes at `offset` in `r`, stopping
+// early only at the end of the file.
+func readAtMost(r io.ReadSeeker, offset int64, buffer []byte) (int, error) {
+	if _, e := r.Seek(offset, io.SeekStart); e != nil {
+		return 0, e
+	}
+	n, e := io.ReadFull(r, buffer)
+	if e == io.EOF || e == io.ErrUnexpectedEOF {
+		e = nil
+	}
+	return n, e
+}
+
+// getAverageBitrate returns the bitrate of `size` bytes that play for
+// `duration` seconds.
+func getAverageBitrate(size int64, duration float64) int {
+	if duration <= 0 || size <= 0 {
+		return 0
+	}
+	return int(float64(size*8) / duration)
+}
+
+// getID3v2Size returns the size of the ID3v2 tag at the start of `r`, if any.
+func getID3v2Size(r io.ReadSeeker) (int64, error) {
+	var h [10]byte
+	n, e := readAtMost(r, 0, h[:])
+	if e != nil {
+		return 0, e
+	}
+	if n < 3 || string(h[:3]) != "ID3" {
+		return 0, nil
+	}
+	if n < len(h) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	size := int64(h[6]&0x7f)<<21 | int64(h[7]&0x7f)<<14 | int64(h[8]&0x7f)<<7 | int64(h[9]&0x7f)
+	size += 10
+	if h[5]&0x10 != 0 {
+		// A footer.
+		size += 10
+	}
+	return size, nil
+}
+
+var (
+	// Bitrates in kbps, by MPEG version (1, or 2 and 2.5), layer, and index.
+	mp3Bitrates = [2][3][16]int{
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		},
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		},
+	}
+
+	// Sample rates by MPEG version (1, 2, and 2.5) and index.
+	mp3SampleRates = [3][3]int{
+		{44100, 48000, 32000},
+		{22050, 24000, 16000},
+		{11025, 12000, 8000},
+	}
+)
+
+type mp3Frame struct {
+	mpeg1      bool
+	bitrate    int
+	sampleRate int
+	channels   int
+	// The length of the frame in bytes, and the number of samples in it.
+	length  int
+	samples int
+}
+
+func parseMP3FrameHeader(h []byte) (mp3Frame, bool) {
+	var f mp3Frame
+	if len(h) < 4 || h[0] != 0xff || h[1]&0xe0 != 0xe0 {
+		return f, false
+	}
+	version := h[1] >> 3 & 3
+	layer := 4 - int(h[1]>>1&3)
+	bitrateIndex := h[2] >> 4
+	sampleRateIndex := h[2] >> 2 & 3
+	padding := int(h[2] >> 1 & 1)
+	// Reserved values, and free-format bitrates, which we cannot measure.
+	if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return f, false
+	}
+
+	f.mpeg1 = version == 3
+	table, rates := 1, 1
+	if f.mpeg1 {
+		table, rates = 0, 0
+	} else if version == 0 {
+		rates = 2
+	}
+	f.bitrate = mp3Bitrates[table][layer-1][bitrateIndex] * 1000
+	f.sampleRate = mp3SampleRates[rates][sampleRateIndex]
+	f.channels = 2
+	if h[3]>>6 == 3 {
+		f.channels = 1
+	}
+	switch {
+	case layer == 1:
+		f.samples = 384
+		f.length = (12*f.bitrate/f.sampleRate + padding) * 4
+	case layer == 2 || f.mpeg1:
+		f.samples = 1152
+		f.length = 144*f.bitrate/f.sampleRate + padding
+	default:
+		f.samples = 576
+		f.length = 72*f.bitrate/f.sampleRate + padding
+	}
+	return f, true
+}
+
+// getMP3FrameCount returns the number of frames in the stream, from the Xing
+// (or Info) or VBRI header in `frame`, the first frame. It returns 0 if there
+// is no such header.
+func getMP3FrameCount(frame []byte, f mp3Frame) int64 {
+	sideInfo := 17
+	if f.mpeg1 && f.channels == 2 {
+		sideInfo = 32
+	} else if !f.mpeg1 && f.channels == 1 {
+		sideInfo = 9
+	}
+	if x := 4 + sideInfo; x+12 <= len(frame) {
+		if tag := string(frame[x : x+4]); tag == "Xing" || tag == "Info" {
+			if binary.BigEndian.Uint32(frame[x+4:])&1 != 0 {
+				return int64(binary.BigEndian.Uint32(frame[x+8:]))
+			}
+		}
+	}
+	if v := 4 + 32; v+18 <= len(frame) && string(frame[v:v+4]) == "VBRI" {
+		return int64(binary.BigEndian.Uint32(frame[v+14:]))
+	}
+	return 0
+}
+
+func readMP3Properties(r io.ReadSeeker, size int64) (AudioProperties, error) {
+	start, e := getID3v2Size(r)
+	if e != nil {
+		return AudioProperties{}, e
+	}
+	end := size
+	var tail [3]byte
+	if size-start >= 128 && readAt(r, size-128, tail[:]) == nil && string(tail[:]) == "TAG" {
+		// An ID3v1 tag.
+		end -= 128
+	}
+
+	buffer := make([]byte, 64*1024)
+	n, e := readAtMost(r, start, buffer)
+	if e != nil {
+		return AudioProperties{}, e
+	}
+	buffer = buffer[:n]
+	for i := 0; i+4 <= len(buffer); i++ {
+		f, ok := parseMP3FrameHeader(buffer[i:])
+		if !ok {
+			continue
+		}
+		// Random data can look like a frame header, but is unlikely to be
+		// followed by another one.
+		if next := i + f.length; next+4 <= len(buffer) {
+			if _, ok := parseMP3FrameHeader(buffer[next:]); !ok {
+				continue
+			}
+		}
+
+		p := AudioProperties{SampleRate: f.sampleRate, Channels: f.channels}
+		audioSize := end - start - int64(i)
+		if frames := getMP3FrameCount(buffer[i:], f); frames > 0 {
+			p.Duration = float64(frames*int64(f.samples)) / float64(f.sampleRate)
+			p.Bitrate = getAverageBitrate(audioSize, p.Duration)
+		} else {
+			// Assume a constant bitrate.
+			p.Bitrate = f.bitrate
+			p.Duration = float64(audioSize*8) / float64(f.bitrate)
+		}
+		return p, nil
+	}
+	return AudioProperties{}, errNoAudioStream
+}
+
+// parseFLACStreamInfo parses the STREAMINFO metadata block `s`.
+func parseFLACStreamInfo(s []byte) AudioProperties {
+	var p AudioProperties
+	p.SampleRate = int(s[10])<<12 | int(s[11])<<4 | int(s[12])>>4
+	p.Channels = int(s[12]>>1&7) + 1
+	samples := int64(s[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(s[14:18]))
+	if p.SampleRate > 0 && samples > 0 {
+		p.Duration = float64(samples) / float64(p.SampleRate)
+	}
+	return p
+}
+
+// walkFLACMetadata calls `f` with the type and extent of the contents of each
+// metadata block of the FLAC stream in `r`. It returns the offset of the audio
+// frames that follow the blocks.
+func walkFLACMetadata(r io.ReadSeeker, size int64, f func(kind byte, start, end int64) error) (int64, error) {
+	offset, e := getID3v2Size(r)
+	if e != nil {
+		return 0, e
+	}
+	var magic [4]byte
+	if e := readAt(r, offset, magic[:]); e != nil {
+		return 0, e
+	}
+	if string(magic[:]) != "fLaC" {
+		return 0, errNoAudioStream
+	}
+	offset += 4
+
+	for last := false; !last; {
+		var h [4]byte
+		if e := readAt(r, offset, h[:]); e != nil {
+			return 0, e
+		}
+		last = h[0]&0x80 != 0
+		length := int64(h[1])<<16 | int64(h[2])<<8 | int64(h[3])
+		offset += 4
+		if offset+length > size {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if e := f(h[0]&0x7f, offset, offset+length); e != nil {
+			return 0, e
+		}
+		offset += length
+	}
+	return offset, nil
+}
+
+func readFLACProperties(r io.ReadSeeker, size int64) (AudioProperties, error) {
+	var p AudioProperties
+	offset, e := walkFLACMetadata(r, size, func(kind byte, start, end int64) error {
+		if kind != 0 || end-start < 34 {
+			return nil
+		}
+		var s [34]byte
+		if e := readAt(r, start, s[:]); e != nil {
+			return e
+		}
+		p = parseFLACStreamInfo(s[:])
+		return nil
+	})
+	if e != nil {
+		return AudioProperties{}, e
+	}
+	if p.SampleRate == 0 {
+		return AudioProperties{}, errNoAudioStream
+	}
+	p.Bitrate = getAverageBitrate(size-offset, p.Duration)
+	return p, nil
+}
+
+// walkRIFFChunks calls `f` with the type and extent of the contents of each
+// chunk of the WAVE file in `r`.
+func walkRIFFChunks(r io.ReadSeeker, size int64, f func(kind string, start, end int64) error) error {
+	var h [12]byte
+	if e := readAt(r, 0, h[:]); e != nil {
+		return e
+	}
+	if string(h[:4]) != "RIFF" || string(h[8:]) != "WAVE" {
+		return errNoAudioStream
+	}
+	for offset := int64(12); offset+8 <= size; {
+		var c [8]byte
+		if e := readAt(r, offset, c[:]); e != nil {
+			return e
+		}
+		length := int64(binary.LittleEndian.Uint32(c[4:]))
+		offset += 8
+		end := offset + length
+		if end > size {
+			// Streaming writers may not know the length in advance.
+			end = size
+		}
+		if e := f(string(c[:4]), offset, end); e != nil {
+			return e
+		}
+		// Chunks are padded to an even length.
+		offset += length + length&1
+	}
+	return nil
+}
+
+func readWAVProperties(r io.ReadSeeker, size int64) (AudioProperties, error) {
+	var p AudioProperties
+	byteRate := 0
+	dataSize := int64(-1)
+	e := walkRIFFChunks(r, size, func(kind string, start, end int64) error {
+		switch kind {
+		case "fmt ":
+			var f [16]byte
+			if end-start < 16 {
+				return errNoAudioStream
+			}
+			if e := readAt(r, start, f[:]); e != nil {
+				return e
+			}
+			p.Channels = int(binary.LittleEndian.Uint16(f[2:]))
+			p.SampleRate = int(binary.LittleEndian.Uint32(f[4:]))
+			byteRate = int(binary.LittleEndian.Uint32(f[8:]))
+		case "data":
+			dataSize = end - start
+		}
+		return nil
+	})
+	if e != nil {
+		return AudioProperties{}, e
+	}
+	if p.SampleRate == 0 {
+		return AudioProperties{}, errNoAudioStream
+	}
+	p.Bitrate = byteRate * 8
+	if byteRate > 0 && dataSize >= 0 {
+		p.Duration = float64(dataSize) / float64(byteRate)
+	}
+	return p, nil
+}
+
+// An oggPage is the header of a page of an Ogg stream, and the extent of its
+// body.
+type oggPage struct {
+	headerType byte
+	granule    int64
+	serial     uint32
+	sequence   uint32
+	// The lacing values, which give the lengths of the packet segments in the
+	// body.
+	segments []byte
+	start    int64
+	end      int64
+}
+
+// readOggPage reads the header of the page at `offset` in `r`.
+func readOggPage(r io.ReadSeeker, offset int64) (oggPage, error) {
+	var p oggPage
+	var h [27]byte
+	if e := readAt(r, offset, h[:]); e != nil {
+		return p, e
+	}
+	if string(h[:4]) != "OggS" {
+		return p, errNoAudioStream
+	}
+	p.headerType = h[5]
+	p.granule = int64(binary.LittleEndian.Uint64(h[6:]))
+	p.serial = binary.LittleEndian.Uint32(h[14:])
+	p.sequence = binary.LittleEndian.Uint32(h[18:])
+	p.segments = make([]byte, h[26])
+	if e := readAt(r, offset+27, p.segments); e != nil {
+		return p, e
+	}
+	p.start = offset + 27 + int64(len(p.segments))
+	p.end = p.start
+	for _, n := range p.segments {
+		p.end += int64(n)
+	}
+	return p, nil
+}
+
+// getLastOggGranule returns the granule position of the last page of the
+// logical stream `serial` in `r`, which for audio is the number of samples in
+// the stream. It returns -1 if there is none.
+func getLastOggGranule(r io.ReadSeeker, size int64, serial uint32) (int64, error) {
+	length := int64(64 * 1024)
+	if length > size {
+		length = size
+	}
+	tail := make([]byte, length)
+	if e := readAt(r, size-length, tail); e != nil {
+		return -1, e
+	}
+	for i := len(tail) - 27; i >= 0; i-- {
+		if string(tail[i:i+4]) != "OggS" || binary.LittleEndian.Uint32(tail[i+14:]) != serial {
+			continue
+		}
+		// Pages on which no packet ends have a granule position of -1.
+		if granule := int64(binary.LittleEndian.Uint64(tail[i+6:])); granule >= 0 {
+			return granule, nil
+		}
+	}
+	return -1, nil
+}
+
+func readOggProperties(r io.ReadSeeker, size int64) (AudioProperties, error) {
+	page, e := readOggPage(r, 0)
+	if e != nil {
+		return AudioProperties{}, e
+	}
+	packet := make([]byte, page.end-page.start)
+	if e := readAt(r, page.start, packet); e != nil {
+		return AudioProperties{}, e
+	}
+
+	var p AudioProperties
+	granuleRate, preSkip, nominalBitrate := 0, 0, 0
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")) && len(packet) >= 24:
+		p.Channels = int(packet[11])
+		p.SampleRate = int(binary.LittleEndian.Uint32(packet[12:]))
+		granuleRate = p.SampleRate
+		nominalBitrate = int(int32(binary.LittleEndian.Uint32(packet[20:])))
+	case bytes.HasPrefix(packet, []byte("OpusHead")) && len(packet) >= 12:
+		p.Channels = int(packet[9])
+		preSkip = int(binary.LittleEndian.Uint16(packet[10:]))
+		// Opus always decodes at 48 kHz, whatever the rate of the original.
+		p.SampleRate = 48000
+		granuleRate = p.SampleRate
+	default:
+		return AudioProperties{}, errNoAudioStream
+	}
+
+	granule, e := getLastOggGranule(r, size, page.serial)
+	if e != nil {
+		return AudioProperties{}, e
+	}
+	if granuleRate > 0 && granule > int64(preSkip) {
+		p.Duration = float64(granule-int64(preSkip)) / float64(granuleRate)
+	}
+	p.Bitrate = getAverageBitrate(size, p.Duration)
+	if p.Bitrate == 0 && nominalBitrate > 0 {
+		p.Bitrate = nominalBitrate
+	}
+	return p, nil
+}
+
+// readMP4Atoms calls `f` with the type and extent of the contents of each atom
+// between `start` and `end` in `r`.
+func readMP4Atoms(r io.ReadSeeker, start, end int64, f func(kind string, start, end int64) error) error {
+	for start+8 <= end {
+		var h [16]byte
+		if e := readAt(r, start, h[:8]); e != nil {
+			return e
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(h[:4])), int64(8)
+		if size == 1 {
+			if e := readAt(r, start+8, h[8:]); e != nil {
+				return e
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(h[8:])), 16
+		} else if size == 0 {
+			// The atom extends to the end of the file.
+			size = end - start
+		}
+		if size < headerSize || size > end-start {
+			return errNoAudioStream
+		}
+		if e := f(string(h[4:8]), start+headerSize, start+size); e != nil {
+			return e
+		}
+		start += size
+	}
+	return nil
+}
+
+// parseMP4Header parses the time scale and duration from the contents of an
+// `mvhd` or `mdhd` atom.
+func parseMP4Header(h []byte) (uint32, uint64) {
+	if h[0] == 1 {
+		return binary.BigEndian.Uint32(h[20:]), binary.BigEndian.Uint64(h[24:])
+	}
+	return binary.BigEndian.Uint32(h[12:]), uint64(binary.BigEndian.Uint32(h[16:]))
+}
+
+// readMP4Track sets the sample rate and channels in `p` from the `trak` atom
+// between `start` and `end`, if it is a sound track.
+func readMP4Track(r io.ReadSeeker, start, end int64, p *AudioProperties) error {
+	isSound := false
+	var timeScale uint32
+	var entry []byte
+	var visit func(kind string, start, end int64) error
+	visit = func(kind string, start, end int64) error {
+		switch kind {
+		case "mdia", "minf", "stbl":
+			return readMP4Atoms(r, start, end, visit)
+		case "hdlr":
+			var h [12]byte
+			if e := readAt(r, start, h[:]); e != nil {
+				return e
+			}
+			isSound = string(h[8:]) == "soun"
+		case "mdhd":
+			var h [32]byte
+			if e := readAt(r, start, h[:]); e != nil {
+				return e
+			}
+			timeScale, _ = parseMP4Header(h[:])
+		case "stsd":
+			// Skip the version, flags, and entry count to the first entry.
+			entry = make([]byte, 36)
+			if e := readAt(r, start+8, entry); e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+	if e := readMP4Atoms(r, start, end, visit); e != nil {
+		return e
+	}
+	if !isSound || p.SampleRate != 0 {
+		return nil
+	}
+	if entry != nil {
+		p.Channels = int(binary.BigEndian.Uint16(entry[24:]))
+		// A 16.16 fixed-point number.
+		p.SampleRate = int(binary.BigEndian.Uint32(entry[32:]) >> 16)
+	}
+	// The time scale of a sound track is normally its sample rate, and unlike
+	// the sample entry, it can be more than 65535.
+	if timeScale != 0 {
+		p.SampleRate = int(timeScale)
+	}
+	return nil
+}
+
+func readMP4Properties(r io.ReadSeeker, size int64) (AudioProperties, error) {
+	var p AudioProperties
+	var dataSize int64
+	movie := false
+	e := readMP4Atoms(r, 0, size, func(kind string, start, end int64) error {
+		switch kind {
+		case "mdat":
+			dataSize += end - start
+		case "moov":
+			movie = true
+			return readMP4Atoms(r, start, end, func(kind string, start, end int64) error {
+				switch kind {
+				case "mvhd":
+					var h [32]byte
+					if e := readAt(r, start, h[:]); e != nil {
+						return e
+					}
+					if timeScale, duration := parseMP4Header(h[:]); timeScale != 0 {
+						p.Duration = float64(duration) / float64(timeScale)
+					}
+				case "trak":
+					return readMP4Track(r, start, end, &p)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if e != nil {
+		return AudioProperties{}, e
+	}
+	if !movie {
+		return AudioProperties{}, errNoAudioStream
+	}
+	if dataSize == 0 {
+		dataSize = size
+	}
+	p.Bitrate = getAverageBitrate(dataSize, p.Duration)
+	return p, nil
+}
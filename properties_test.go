@@ -0,0 +1,166 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func checkAudioProperties(t *testing.T, pathname string, data []byte, expected AudioProperties) {
+	t.Helper()
+	p, e := readAudioProperties(pathname, bytes.NewReader(data), int64(len(data)))
+	if e != nil {
+		t.Fatalf("%s: %v", pathname, e)
+	}
+	if math.Abs(p.Duration-expected.Duration) > 0.001 || p.Bitrate != expected.Bitrate || p.SampleRate != expected.SampleRate || p.Channels != expected.Channels {
+		t.Errorf("%s: expected %+v, got %+v", pathname, expected, p)
+	}
+}
+
+func makeMP3(frames int, first []byte) []byte {
+	// MPEG 1 layer 3, 128 kbps, 44.1 kHz, stereo: 417 bytes per frame.
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	var data []byte
+	for i := 0; i < frames; i++ {
+		f := append([]byte{}, frame...)
+		if i == 0 {
+			copy(f[4:], first)
+		}
+		data = append(data, f...)
+	}
+	return data
+}
+
+func TestReadMP3Properties(t *testing.T) {
+	tag := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x0a"), make([]byte, 10)...)
+	data := append(tag, makeMP3(10, nil)...)
+	checkAudioProperties(t, "cbr.mp3", data, AudioProperties{Duration: 4170 * 8 / 128000.0, Bitrate: 128000, SampleRate: 44100, Channels: 2})
+
+	// A Xing header says there are 100 frames.
+	xing := make([]byte, 32+12)
+	copy(xing[32:], "Xing\x00\x00\x00\x01\x00\x00\x00\x64")
+	duration := 100 * 1152 / 44100.0
+	checkAudioProperties(t, "vbr.mp3", makeMP3(10, xing), AudioProperties{Duration: duration, Bitrate: int(4170 * 8 / duration), SampleRate: 44100, Channels: 2})
+
+	checkAudioProperties(t, "junk.mp3", []byte("not really an MP3 file"), AudioProperties{})
+}
+
+func TestReadWAVProperties(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("RIFF\x00\x00\x00\x00WAVE")
+	b.WriteString("fmt ")
+	binary.Write(&b, binary.LittleEndian, []uint32{16})
+	binary.Write(&b, binary.LittleEndian, []uint16{1, 2})
+	binary.Write(&b, binary.LittleEndian, []uint32{44100, 44100 * 4})
+	binary.Write(&b, binary.LittleEndian, []uint16{4, 16})
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, []uint32{44100 * 4 * 2})
+	b.Write(make([]byte, 44100*4*2))
+	checkAudioProperties(t, "a.wav", b.Bytes(), AudioProperties{Duration: 2, Bitrate: 1411200, SampleRate: 44100, Channels: 2})
+}
+
+func TestReadFLACProperties(t *testing.T) {
+	streamInfo := make([]byte, 34)
+	binary.BigEndian.PutUint64(streamInfo[10:], uint64(96000)<<44|uint64(2-1)<<41|uint64(24-1)<<36|96000*3)
+	data := append([]byte("fLaC\x80\x00\x00\x22"), streamInfo...)
+	data = append(data, make([]byte, 36000)...)
+	checkAudioProperties(t, "a.flac", data, AudioProperties{Duration: 3, Bitrate: 96000, SampleRate: 96000, Channels: 2})
+}
+
+func makeOggPage(headerType byte, granule uint64, packet []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("OggS\x00")
+	b.WriteByte(headerType)
+	binary.Write(&b, binary.LittleEndian, granule)
+	binary.Write(&b, binary.LittleEndian, []uint32{1234, 0, 0})
+	b.WriteByte(1)
+	b.WriteByte(byte(len(packet)))
+	b.Write(packet)
+	return b.Bytes()
+}
+
+func TestReadOggProperties(t *testing.T) {
+	var identification bytes.Buffer
+	identification.WriteString("\x01vorbis")
+	binary.Write(&identification, binary.LittleEndian, []uint32{0})
+	identification.WriteByte(1)
+	binary.Write(&identification, binary.LittleEndian, []uint32{44100, 0, 64000, 0})
+	identification.WriteString("\xb8\x01")
+	data := makeOggPage(2, 0, identification.Bytes())
+	data = append(data, makeOggPage(0, 44100, make([]byte, 200))...)
+	data = append(data, makeOggPage(4, 44100*5, make([]byte, 200))...)
+	checkAudioProperties(t, "a.ogg", data, AudioProperties{Duration: 5, Bitrate: len(data) * 8 / 5, SampleRate: 44100, Channels: 1})
+}
+
+func makeMP4Atom(kind string, contents ...[]byte) []byte {
+	body := bytes.Join(contents, nil)
+	atom := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(atom, uint32(8+len(body)))
+	copy(atom[4:], kind)
+	return append(atom, body...)
+}
+
+func TestReadMP4Properties(t *testing.T) {
+	header := func(timeScale, duration uint32) []byte {
+		h := make([]byte, 100)
+		binary.BigEndian.PutUint32(h[12:], timeScale)
+		binary.BigEndian.PutUint32(h[16:], duration)
+		return h
+	}
+	handler := make([]byte, 24)
+	copy(handler[8:], "soun")
+	entry := make([]byte, 36)
+	copy(entry[4:], "mp4a")
+	binary.BigEndian.PutUint16(entry[24:], 2)
+	binary.BigEndian.PutUint32(entry[32:], 48000<<16)
+
+	data := bytes.Join([][]byte{
+		makeMP4Atom("ftyp", []byte("M4A \x00\x00\x00\x00")),
+		makeMP4Atom("mdat", make([]byte, 16000)),
+		makeMP4Atom("moov",
+			makeMP4Atom("mvhd", header(1000, 4000)),
+			makeMP4Atom("trak",
+				makeMP4Atom("mdia",
+					makeMP4Atom("mdhd", header(48000, 48000*4)),
+					makeMP4Atom("hdlr", handler),
+					makeMP4Atom("minf",
+						makeMP4Atom("stbl",
+							makeMP4Atom("stsd", []byte{0, 0, 0, 0, 0, 0, 0, 1}, entry)))))),
+	}, nil)
+	checkAudioProperties(t, "a.m4a", data, AudioProperties{Duration: 4, Bitrate: 32000, SampleRate: 48000, Channels: 2})
+}
+
+func TestMatchNumericKeywords(t *testing.T) {
+	items := ItemInfos{
+		{Pathname: "short.mp3", Size: 3 << 20, AudioProperties: AudioProperties{Duration: 185.5, Bitrate: 319800, SampleRate: 44100, Channels: 2}},
+		{Pathname: "long.flac", Size: 2 << 30, AudioProperties: AudioProperties{Duration: 1500, Bitrate: 2304000, SampleRate: 96000, Channels: 2}},
+		{Pathname: "unknown.mid", Size: 1000},
+	}
+	for query, expected := range map[string][]string{
+		"length:>20m":      {"long.flac"},
+		"length:<1h30m":    {"short.mp3", "long.flac"},
+		"length:185":       {"short.mp3"},
+		"length:-<1m":      {"short.mp3", "long.flac", "unknown.mid"},
+		"samplerate:96000": {"long.flac"},
+		"samplerate:44.1k": {"short.mp3"},
+		"bitrate:320":      {"short.mp3"},
+		"bitrate:>=1m":     {"long.flac"},
+		"channels:1":       {},
+		"size:>1g":         {"long.flac"},
+		"size:<=1k":        {"unknown.mid"},
+		"length:>twenty":   {},
+	} {
+		var got []string
+		for _, item := range matchItems(items, query) {
+			got = append(got, item.Pathname)
+		}
+		if !stringSlicesEqual(got, expected) {
+			t.Errorf("%q: expected %q, got %q", query, expected, got)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const rootsBasename = "roots"
+
+// A musicRoot is a directory tree of media files. Its items are cataloged and
+// served under the URL prefix /Name/, or at the top level if `Name` is empty
+// (which is possible only if it is the only root).
+type musicRoot struct {
+	Name     string
+	Pathname string
+	// `Pathname` with any symbolic links followed, resolved once by
+	// `parseMusicRoot` rather than on every request. See `allows`.
+	realPathname string
+}
+
+// musicRoots implements `flag.Value`, so that -m can be given more than once.
+type musicRoots []musicRoot
+
+var (
+	rootNameMatcher = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+	// Root names must not shadow the server's own endpoints.
+	reservedRootNames = []string{
+		"admin",
+		"albums",
+		"artists",
+		"search",
+		"track",
+	}
+)
+
+// parseMusicRoot parses `s`, which is either "name=pathname" or just
+// "pathname".
+func parseMusicRoot(s string) musicRoot {
+	var r musicRoot
+	if i := strings.Index(s, "="); i > 0 && rootNameMatcher.MatchString(s[:i]) {
+		r.Name, s = s[:i], s[i+1:]
+	}
+	r.Pathname = strings.TrimRight(s, string(os.PathSeparator))
+	r.realPathname, _ = filepath.EvalSymlinks(r.Pathname)
+	return r
+}
+
+func (rs *musicRoots) String() string {
+	var parts []string
+	for _, r := range *rs {
+		if r.Name == "" {
+			parts = append(parts, r.Pathname)
+		} else {
+			parts = append(parts, r.Name+"="+r.Pathname)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (rs *musicRoots) Set(s string) error {
+	*rs = append(*rs, parseMusicRoot(s))
+	return nil
+}
+
+// readMusicRootsFile reads roots from the file at `pathname`, which has one
+// "name=pathname" per line. Blank lines and lines beginning with '#' are
+// ignored.
+func readMusicRootsFile(pathname string) (musicRoots, error) {
+	file, e := os.Open(pathname)
+	if e != nil {
+		return nil, e
+	}
+	defer file.Close()
+
+	var roots musicRoots
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		roots = append(roots, parseMusicRoot(line))
+	}
+	return roots, scanner.Err()
+}
+
+// validate returns an error if `rs` cannot be served together: if there is an
+// unnamed root as well as others, or if names are reserved or repeated.
+func (rs musicRoots) validate() error {
+	if len(rs) == 0 {
+		return fmt.Errorf("no music directory given")
+	}
+	names := map[string]bool{}
+	for _, r := range rs {
+		if r.Name == "" {
+			if len(rs) > 1 {
+				return fmt.Errorf("%q needs a name, because there is more than one music directory", r.Pathname)
+			}
+			continue
+		}
+		for _, reserved := range reservedRootNames {
+			if r.Name == reserved {
+				return fmt.Errorf("%q is reserved, and cannot be a music directory name", r.Name)
+			}
+		}
+		if names[r.Name] {
+			return fmt.Errorf("the music directory name %q is used more than once", r.Name)
+		}
+		names[r.Name] = true
+	}
+	return nil
+}
+
+// getCatalogDirectory returns the directory that holds the catalog of `rs`. A
+// lone unnamed root holds its own catalog; otherwise, it goes in the
+// configuration directory.
+func (rs musicRoots) getCatalogDirectory(configurationPathname string) string {
+	if len(rs) == 1 && rs[0].Name == "" {
+		return rs[0].Pathname
+	}
+	return configurationPathname
+}
+
+// getWebPathname returns the (unescaped) web pathname of the file at
+// `pathname`, which must be in `r`.
+func (r musicRoot) getWebPathname(pathname string) string {
+	relative := strings.TrimPrefix(filepath.ToSlash(pathname[len(r.Pathname):]), "/")
+	if r.Name == "" {
+		return relative
+	}
+	if relative == "" {
+		return r.Name
+	}
+	return r.Name + "/" + relative
+}
+
+// isFilePathnameWithin returns true if the file system pathname `pathname` is
+// `dir`, or is inside `dir`.
+func isFilePathnameWithin(pathname, dir string) bool {
+	return pathname == dir || strings.HasPrefix(pathname, dir+string(os.PathSeparator))
+}
+
+// find returns the root that contains the file system pathname `pathname`.
+func (rs musicRoots) find(pathname string) (musicRoot, bool) {
+	for _, r := range rs {
+		if isFilePathnameWithin(pathname, r.Pathname) {
+			return r, true
+		}
+	}
+	return musicRoot{}, false
+}
+
+// resolve maps the (unescaped) URL path `p` to a file system pathname, and
+// returns false if it is not inside any root.
+func (rs musicRoots) resolve(p string) (string, bool) {
+	p = path.Clean("/" + p)
+	for _, r := range rs {
+		if r.Name == "" {
+			return filepath.Join(r.Pathname, filepath.FromSlash(p)), true
+		}
+		prefix := "/" + r.Name
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return filepath.Join(r.Pathname, filepath.FromSlash(p[len(prefix):])), true
+		}
+	}
+	return "", false
+}
+
+// allows returns true if the file system pathname `pathname` exists and, once
+// any symbolic links are followed, is inside one of the roots.
+func (rs musicRoots) allows(pathname string) bool {
+	real, e := filepath.EvalSymlinks(pathname)
+	if e != nil {
+		return false
+	}
+	for _, r := range rs {
+		root := r.realPathname
+		if root == "" {
+			// The root did not exist when it was parsed, or was not parsed at all.
+			if root, e = filepath.EvalSymlinks(r.Pathname); e != nil {
+				continue
+			}
+		}
+		if isFilePathnameWithin(real, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRoot returns true if the file system pathname `pathname` is the top of one
+// of the roots.
+func (rs musicRoots) isRoot(pathname string) bool {
+	for _, r := range rs {
+		if filepath.Clean(r.Pathname) == filepath.Clean(pathname) {
+			return true
+		}
+	}
+	return false
+}
+
+// getItemFilePathname returns the file system pathname of `i`.
+func (rs musicRoots) getItemFilePathname(i *ItemInfo) string {
+	pathname, _ := rs.resolve(unescapePathname(i.Pathname))
+	return pathname
+}
@@ -0,0 +1,108 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseMusicRoot(t *testing.T) {
+	expectations := []struct {
+		Input  string
+		Output musicRoot
+	}{
+		{"/srv/music/", musicRoot{Name: "", Pathname: "/srv/music"}},
+		{"music=/srv/music", musicRoot{Name: "music", Pathname: "/srv/music"}},
+		{"/srv/a=b", musicRoot{Name: "", Pathname: "/srv/a=b"}},
+		{"concert-videos=/mnt/video", musicRoot{Name: "concert-videos", Pathname: "/mnt/video"}},
+	}
+	for _, e := range expectations {
+		if r := parseMusicRoot(e.Input); r.Name != e.Output.Name || r.Pathname != e.Output.Pathname {
+			t.Errorf("%q: expected %v, got %v", e.Input, e.Output, r)
+		}
+	}
+}
+
+func TestMusicRootsValidate(t *testing.T) {
+	valid := []musicRoots{
+		{{Name: "", Pathname: "/srv/music"}},
+		{{Name: "music", Pathname: "/srv/music"}},
+		{{Name: "music", Pathname: "/srv/music"}, {Name: "books", Pathname: "/srv/books"}},
+	}
+	for _, rs := range valid {
+		if e := rs.validate(); e != nil {
+			t.Errorf("%v: %v", rs, e)
+		}
+	}
+	invalid := []musicRoots{
+		{},
+		{{Name: "", Pathname: "/srv/music"}, {Name: "books", Pathname: "/srv/books"}},
+		{{Name: "music", Pathname: "/srv/music"}, {Name: "music", Pathname: "/srv/books"}},
+		{{Name: "search", Pathname: "/srv/music"}},
+	}
+	for _, rs := range invalid {
+		if e := rs.validate(); e == nil {
+			t.Errorf("%v: expected an error", rs)
+		}
+	}
+}
+
+func TestMusicRootsResolve(t *testing.T) {
+	rs := musicRoots{{Name: "music", Pathname: "/srv/music"}, {Name: "books", Pathname: "/srv/books"}}
+	expectations := []struct {
+		Input    string
+		Pathname string
+		OK       bool
+	}{
+		{"/music/Artist/Album/01 Track.mp3", "/srv/music/Artist/Album/01 Track.mp3", true},
+		{"/books/Author", "/srv/books/Author", true},
+		{"/books", "/srv/books", true},
+		{"/music/../books/x", "/srv/books/x", true},
+		{"/music/../../etc/passwd", "", false},
+		{"/musicals/x", "", false},
+		{"/index.html", "", false},
+	}
+	for _, e := range expectations {
+		pathname, ok := rs.resolve(e.Input)
+		if pathname != e.Pathname || ok != e.OK {
+			t.Errorf("%q: expected %q, %t; got %q, %t", e.Input, e.Pathname, e.OK, pathname, ok)
+		}
+	}
+
+	single := musicRoots{{Name: "", Pathname: "/srv/music"}}
+	if pathname, ok := single.resolve("/../../etc/passwd"); !ok || pathname != "/srv/music/etc/passwd" {
+		t.Errorf("expected confinement to the root, got %q", pathname)
+	}
+}
+
+func TestMusicRootGetWebPathname(t *testing.T) {
+	named := musicRoot{Name: "music", Pathname: "/srv/music"}
+	unnamed := musicRoot{Name: "", Pathname: "/srv/music"}
+	if p := named.getWebPathname("/srv/music/A/B.mp3"); p != "music/A/B.mp3" {
+		t.Errorf("got %q", p)
+	}
+	if p := named.getWebPathname("/srv/music"); p != "music" {
+		t.Errorf("got %q", p)
+	}
+	if p := unnamed.getWebPathname("/srv/music/A/B.mp3"); p != "A/B.mp3" {
+		t.Errorf("got %q", p)
+	}
+}
+
+func TestMatchItemsRoot(t *testing.T) {
+	items := ItemInfos{
+		{Pathname: "music/A/B/01 Song.mp3", Root: "music"},
+		{Pathname: "books/A/B/01 Chapter.mp3", Root: "books"},
+	}
+	for i := range items {
+		items[i].fillMetadata()
+	}
+	if items[0].Artist != "A" {
+		t.Errorf("expected the artist to come from within the root, got %q", items[0].Artist)
+	}
+	matches := matchItems(items, "root:books")
+	if len(matches) != 1 || matches[0].Root != "books" {
+		t.Errorf("unexpected matches %v", matches)
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	scanReportBasename = "catalog-report.json"
+	eraseLine          = "\033[2K\r"
+)
+
+// Stages of cataloging a file, for `scanError.Stage`.
+const (
+	scanStageWalk       = "walk"
+	scanStageOpen       = "open"
+	scanStageTags       = "tags"
+	scanStageProperties = "properties"
+	scanStageID         = "id"
+	scanStageClose      = "close"
+)
+
+// A scanError records a problem with one file or directory during a scan.
+type scanError struct {
+	Pathname string `json:"pathname"`
+	Stage    string `json:"stage"`
+	Message  string `json:"error"`
+}
+
+func newScanError(pathname, stage string, e error) *scanError {
+	return &scanError{Pathname: pathname, Stage: stage, Message: e.Error()}
+}
+
+func (e *scanError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Stage, e.Pathname, e.Message)
+}
+
+// A scanReport summarizes a scan, and lists the problems it encountered.
+type scanReport struct {
+	Items     int           `json:"items"`
+	Unchanged int           `json:"unchanged"`
+	Read      int           `json:"read"`
+	Moved     int           `json:"moved"`
+	Ignored   []ignoreCount `json:"ignored"`
+	Errors    []*scanError  `json:"errors"`
+}
+
+func (r *scanReport) writeToFile(pathname string) error {
+	data, e := json.MarshalIndent(r, "", "  ")
+	if e != nil {
+		return e
+	}
+	return os.WriteFile(pathname, append(data, '\n'), 0644)
+}
+
+func (r *scanReport) printSummary(w io.Writer) {
+	fmt.Fprintf(w, "%d items: %d unchanged, %d read, %d moved, %d errors\n", r.Items, r.Unchanged, r.Read, r.Moved, len(r.Errors))
+	for _, c := range r.Ignored {
+		fmt.Fprintf(w, "    ignored %d files and %d directories: %s\n", c.Files, c.Directories, c.Rule)
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(w, "    %s\n", e)
+	}
+}
+
+// scanOptions control how `newCatalog` and `withChanges` scan music
+// directories.
+type scanOptions struct {
+	// The number of files to read concurrently.
+	Workers int
+
+	// Rules from the global ignore file, which apply to every root.
+	IgnoreRules []*ignoreRule
+
+	// Whether to follow symbolic links to files and directories (that are
+	// themselves in a root). See `treeWalker`.
+	FollowSymlinks bool
+}
+
+// formatDate formats `t` as YYYY-MM-DD, for `ItemInfo.ModTime` and
+// `ItemInfo.Added`.
+func formatDate(t time.Time) string {
+	return fmt.Sprintf("%04d-%02d-%02d", t.Year(), t.Month(), t.Day())
+}
+
+func shouldSkipFile(info os.FileInfo) bool {
+	return info.Name() == "" || info.Name()[0] == '.' || info.Size() == 0 || info.Mode().IsDir() || !info.Mode().IsRegular()
+}
+
+// readItemInfo reads the metadata for the file at `pathname`, which is in
+// `root`. If the file cannot be opened, it returns nil and an error. It may
+// also return both an item and an error, if the file could be cataloged but
+// with some problem, such as malformed tags.
+//
+// The item is marked as added today; callers that know better should carry
+// over `Added` and `ID` from the item's previous version.
+func readItemInfo(root musicRoot, pathname string, info os.FileInfo) (*ItemInfo, *scanError) {
+	itemInfo := ItemInfo{Pathname: root.getWebPathname(pathname), Root: root.Name}
+
+	input, e := os.Open(pathname)
+	if e != nil {
+		return nil, newScanError(pathname, scanStageOpen, e)
+	}
+	var problem *scanError
+	itemInfo.Tags, e = readTags(pathname, input, info.Size())
+	if e != nil {
+		problem = newScanError(pathname, scanStageTags, e)
+	}
+	itemInfo.AudioProperties, e = readAudioProperties(pathname, input, info.Size())
+	if e != nil && problem == nil {
+		problem = newScanError(pathname, scanStageProperties, e)
+	}
+	itemInfo.ID, e = readItemID(pathname, input, info.Size())
+	if e != nil && problem == nil {
+		problem = newScanError(pathname, scanStageID, e)
+	}
+	if e := input.Close(); e != nil && problem == nil {
+		problem = newScanError(pathname, scanStageClose, e)
+	}
+
+	itemInfo.ModTime = formatDate(info.ModTime())
+	itemInfo.Added = formatDate(time.Now())
+	itemInfo.FileModTime = info.ModTime()
+	itemInfo.Size = info.Size()
+	itemInfo.fillMetadata()
+	return &itemInfo, problem
+}
+
+// readMissing returns a copy of `previous`, which is current for the file at
+// `pathname`, with what the catalog it came from did not record: the ID of
+// items from before there were IDs, and the audio properties of items from
+// before there were properties. The tags are not read again.
+func readMissing(pathname string, previous *ItemInfo) (*ItemInfo, *scanError) {
+	itemInfo := *previous
+	input, e := os.Open(pathname)
+	if e != nil {
+		return nil, newScanError(pathname, scanStageOpen, e)
+	}
+	var problem *scanError
+	if itemInfo.MissingProperties {
+		itemInfo.AudioProperties, e = readAudioProperties(pathname, input, itemInfo.Size)
+		if e != nil {
+			problem = newScanError(pathname, scanStageProperties, e)
+		}
+		itemInfo.MissingProperties = false
+	}
+	if itemInfo.ID == "" {
+		itemInfo.ID, e = readItemID(pathname, input, itemInfo.Size)
+		if e != nil && problem == nil {
+			problem = newScanError(pathname, scanStageID, e)
+		}
+	}
+	if e := input.Close(); e != nil && problem == nil {
+		problem = newScanError(pathname, scanStageClose, e)
+	}
+	return &itemInfo, problem
+}
+
+// A scanJob is a file that `newCatalog` found in `root`, and `index` is its
+// position in the walk order. If the walk failed at this pathname, `e` says
+// why, and `carried` holds the previous catalog's items from beneath it.
+type scanJob struct {
+	index    int
+	root     musicRoot
+	pathname string
+	info     os.FileInfo
+	e        *scanError
+	carried  ItemInfos
+}
+
+type scanResult struct {
+	scanJob
+	itemInfo *ItemInfo
+	reused   bool
+}
+
+// newCatalog walks the trees at `roots` and returns a catalog of the audio and
+// video files in them, and a report of the scan. If `previous` is not nil,
+// items in it whose files have not changed size or modification time are
+// reused rather than re-read. Items for files that no longer exist are
+// dropped.
+//
+// Problems with individual files are recorded in the report, and do not stop
+// the scan. If a directory cannot be read, the items beneath it are carried
+// over from `previous`.
+//
+// Files and directories that match the ignore rules are skipped, and the report
+// counts how many each rule excluded. Symbolic links are followed only if
+// `options.FollowSymlinks` is set.
+//
+// Up to `options.Workers` files are read concurrently. The items in the
+// resulting catalog are in walk order regardless.
+func newCatalog(log *log.Logger, roots musicRoots, previous *Catalog, options scanOptions) (*Catalog, *scanReport) {
+	var c Catalog
+	var report scanReport
+	if previous == nil {
+		previous = &Catalog{}
+	}
+	previousItems := previous.byPathname()
+
+	jobs := make(chan scanJob)
+	results := make(chan scanResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				result := scanResult{scanJob: job}
+				if job.e == nil {
+					p, ok := previousItems[pathnameEscape(job.root.getWebPathname(job.pathname))]
+					if ok && p.isCurrent(job.info) {
+						if p.ID == "" || p.MissingProperties {
+							result.itemInfo, result.e = readMissing(job.pathname, p)
+						} else {
+							result.itemInfo, result.reused = p, true
+						}
+					} else {
+						result.itemInfo, result.e = readItemInfo(job.root, job.pathname, job.info)
+						// Moves are found once the walk is done; see `carryOverMoves`.
+						if result.itemInfo != nil && ok {
+							result.itemInfo.carryOver(p)
+						}
+					}
+				}
+				results <- result
+			}
+		}()
+	}
+
+	ignores := newIgnoreMatcher(options.IgnoreRules, func(e error) { log.Print(e) })
+	walker := newTreeWalker(roots, options.FollowSymlinks)
+	go func() {
+		count := 0
+		for _, root := range roots {
+			_ = walker.Walk(root.Pathname,
+				func(pathname string, info os.FileInfo, e error) error {
+					var job scanJob
+					if e != nil {
+						job = scanJob{pathname: pathname, e: newScanError(pathname, scanStageWalk, e)}
+						if info != nil && info.IsDir() {
+							dir := pathnameEscape(root.getWebPathname(pathname))
+							for _, item := range previous.ItemInfos {
+								if isPathnameWithin(item.Pathname, dir) {
+									job.carried = append(job.carried, item)
+								}
+							}
+						}
+					} else if info.IsDir() {
+						if ignores.shouldIgnore(root.Pathname, pathname, true) {
+							return filepath.SkipDir
+						}
+						return nil
+					} else if shouldSkipFile(info) || !(isAudioPathname(pathname) || isVideoPathname(pathname)) || ignores.shouldIgnore(root.Pathname, pathname, false) {
+						return nil
+					} else {
+						job = scanJob{pathname: pathname, info: info}
+					}
+					job.index = count
+					job.root = root
+					count++
+					jobs <- job
+					// Returning nil from a failed directory skips it, and continues the walk.
+					return nil
+				})
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results arrive in whatever order the workers finish them. Hold on to them
+	// until all of their predecessors have arrived, so that the catalog and the
+	// progress indicator follow the walk order.
+	pending := map[int]scanResult{}
+	next := 0
+	previousDir := ""
+	for result := range results {
+		pending[result.index] = result
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			// If we have progressed to a new directory, print progress indicator.
+			if dir := path.Dir(path.Dir(r.root.getWebPathname(r.pathname))); dir != previousDir {
+				fmt.Fprintf(os.Stdout, "%s%s", eraseLine, dir)
+				previousDir = dir
+			}
+
+			if r.e != nil {
+				log.Print(r.e)
+				report.Errors = append(report.Errors, r.e)
+			}
+			c.ItemInfos = append(c.ItemInfos, r.carried...)
+			report.Unchanged += len(r.carried)
+			if r.itemInfo == nil {
+				continue
+			}
+			if r.reused {
+				report.Unchanged++
+			} else {
+				report.Read++
+			}
+			c.ItemInfos = append(c.ItemInfos, *r.itemInfo)
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "%s\n", eraseLine)
+	report.Items = len(c.ItemInfos)
+	items := c.byPathname()
+	report.Moved = carryOverMoves(c.ItemInfos, previous, func(pathname string) bool {
+		_, ok := items[pathname]
+		return !ok
+	})
+	c.assignUniqueIDs(previous)
+	// The walk goroutine is done with `ignores` once it closes `results`.
+	report.Ignored = ignores.getCounts()
+	c.aggregate(roots, nil)
+	return &c, &report
+}
+
+// carryOverMoves carries over to each item in `items` at a pathname that is not
+// in `previous` what it should keep from an item in `previous` with the same
+// audio, as long as `isGone` says that the previous item's pathname no longer
+// exists: that is, if the file moved. (If it still exists, the new file is a
+// copy, and is new.) Each previous item moves at most once. It returns how many
+// items moved.
+func carryOverMoves(items ItemInfos, previous *Catalog, isGone func(pathname string) bool) int {
+	previousItems := previous.byPathname()
+	gone := map[string][]*ItemInfo{}
+	for i := range previous.ItemInfos {
+		p := &previous.ItemInfos[i]
+		if p.ID != "" && isGone(p.Pathname) {
+			hash := getAudioHash(p.ID)
+			gone[hash] = append(gone[hash], p)
+		}
+	}
+
+	moved := 0
+	for i := range items {
+		item := &items[i]
+		if _, ok := previousItems[item.Pathname]; ok || item.ID == "" {
+			continue
+		}
+		hash := getAudioHash(item.ID)
+		if candidates := gone[hash]; len(candidates) > 0 {
+			item.carryOver(candidates[0])
+			gone[hash] = candidates[1:]
+			moved++
+		}
+	}
+	return moved
+}
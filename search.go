@@ -4,7 +4,10 @@
 package main
 
 import (
+	"math"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func normalizeStringForSearch(s string) string {
@@ -12,6 +15,96 @@ func normalizeStringForSearch(s string) string {
 	return strings.ToLower(normalized)
 }
 
+// A numericKeyword is a search keyword for a numeric property of items. Its
+// terms are comparisons, such as "length:>20m" or "samplerate:96k". Items
+// whose property is unknown never match.
+type numericKeyword struct {
+	// Returns the property, rounded to the precision people search by.
+	value func(*ItemInfo) float64
+	// Maps unit suffixes, including "", to multipliers.
+	units map[string]float64
+	// Whether terms can also be durations in seconds, such as "1h30m".
+	isDuration bool
+}
+
+var numericKeywords = map[string]numericKeyword{
+	"length": {
+		func(i *ItemInfo) float64 { return math.Floor(i.Duration) },
+		map[string]float64{"": 1, "s": 1, "m": 60, "h": 3600},
+		true,
+	},
+	"bitrate": {
+		func(i *ItemInfo) float64 { return math.Round(float64(i.Bitrate)/1000) * 1000 },
+		map[string]float64{"": 1000, "k": 1000, "kbps": 1000, "m": 1000000, "mbps": 1000000},
+		false,
+	},
+	"samplerate": {
+		func(i *ItemInfo) float64 { return float64(i.SampleRate) },
+		map[string]float64{"": 1, "hz": 1, "k": 1000, "khz": 1000},
+		false,
+	},
+	"channels": {
+		func(i *ItemInfo) float64 { return float64(i.Channels) },
+		map[string]float64{"": 1},
+		false,
+	},
+	"size": {
+		func(i *ItemInfo) float64 { return float64(i.Size) },
+		map[string]float64{"": 1, "k": 1 << 10, "kb": 1 << 10, "m": 1 << 20, "mb": 1 << 20, "g": 1 << 30, "gb": 1 << 30},
+		false,
+	},
+}
+
+// parseQuantity parses `s`, a number followed by one of `units`.
+func (k numericKeyword) parseQuantity(s string) (float64, bool) {
+	i := strings.IndexFunc(s, func(r rune) bool { return !(r >= '0' && r <= '9' || r == '.') })
+	if i < 0 {
+		i = len(s)
+	}
+	n, e := strconv.ParseFloat(s[:i], 64)
+	if e != nil {
+		return 0, false
+	}
+	multiplier, ok := k.units[s[i:]]
+	return n * multiplier, ok
+}
+
+func (k numericKeyword) match(info *ItemInfo, term string) bool {
+	value := k.value(info)
+	if value == 0 {
+		return false
+	}
+	operator := "="
+	for _, o := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(term, o) {
+			operator, term = o, term[len(o):]
+			break
+		}
+	}
+	n, ok := k.parseQuantity(term)
+	if !ok {
+		d, e := time.ParseDuration(term)
+		if e != nil || !k.isDuration {
+			return false
+		}
+		n = d.Seconds()
+	}
+	// All the properties are whole numbers, but units can make fractions, such
+	// as 44.1k.
+	n = math.Round(n)
+	switch operator {
+	case ">=":
+		return value >= n
+	case "<=":
+		return value <= n
+	case ">":
+		return value > n
+	case "<":
+		return value < n
+	}
+	return value == n
+}
+
 func matchItem(info *ItemInfo, queries []Query) bool {
 	for _, query := range queries {
 		matched := false
@@ -31,7 +124,13 @@ func matchItem(info *ItemInfo, queries []Query) bool {
 			matched = strings.Contains(info.NormalizedYear, query.Term)
 		} else if query.Keyword == "genre" {
 			matched = strings.Contains(info.NormalizedGenre, query.Term)
-		} else if query.Keyword == "mtime" || query.Keyword == "added" {
+		} else if query.Keyword == "root" {
+			matched = strings.EqualFold(info.Root, query.Term)
+		} else if k, ok := numericKeywords[query.Keyword]; ok {
+			matched = k.match(info, query.Term)
+		} else if query.Keyword == "added" {
+			matched = strings.Contains(info.Added, query.Term)
+		} else if query.Keyword == "mtime" {
 			matched = strings.Contains(info.ModTime, query.Term)
 		} else {
 			if strings.Contains(info.NormalizedPathname, query.Term) ||
@@ -42,6 +141,7 @@ func matchItem(info *ItemInfo, queries []Query) bool {
 				strings.Contains(info.NormalizedTrack, query.Term) ||
 				strings.Contains(info.NormalizedYear, query.Term) ||
 				strings.Contains(info.NormalizedGenre, query.Term) ||
+				strings.Contains(info.Added, query.Term) ||
 				strings.Contains(info.ModTime, query.Term) {
 				matched = true
 			}
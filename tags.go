@@ -0,0 +1,455 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Tags that are longer than this are assumed to be malformed, rather than read
+// into memory.
+const maxTagSize = 16 << 20
+
+var errMalformedTags = errors.New("malformed tags")
+
+// readBlock reads the `end - start` bytes at `start` in `r`.
+func readBlock(r io.ReadSeeker, start, end int64) ([]byte, error) {
+	if end-start > maxTagSize {
+		return nil, errMalformedTags
+	}
+	data := make([]byte, end-start)
+	return data, readAt(r, start, data)
+}
+
+// A fieldReader reads length-prefixed fields from a tag.
+type fieldReader struct {
+	data  []byte
+	order binary.ByteOrder
+	e     error
+}
+
+func (f *fieldReader) uint32() uint32 {
+	if f.e != nil || len(f.data) < 4 {
+		f.e = errMalformedTags
+		return 0
+	}
+	n := f.order.Uint32(f.data)
+	f.data = f.data[4:]
+	return n
+}
+
+func (f *fieldReader) bytes() []byte {
+	n := f.uint32()
+	if f.e != nil || uint64(n) > uint64(len(f.data)) {
+		f.e = errMalformedTags
+		return nil
+	}
+	b := f.data[:n]
+	f.data = f.data[n:]
+	return b
+}
+
+// vorbisCommentFields maps Vorbis comment field names to the fields of
+// `Metadata`.
+var vorbisCommentFields = map[string]func(*Metadata) *string{
+	"TITLE":       func(f *Metadata) *string { return &f.Name },
+	"ARTIST":      func(f *Metadata) *string { return &f.Artist },
+	"ALBUM":       func(f *Metadata) *string { return &f.Album },
+	"DATE":        func(f *Metadata) *string { return &f.Year },
+	"TRACKNUMBER": func(f *Metadata) *string { return &f.Track },
+	"DISCNUMBER":  func(f *Metadata) *string { return &f.Disc },
+	"GENRE":       func(f *Metadata) *string { return &f.Genre },
+	"ALBUMARTIST": func(f *Metadata) *string { return &f.AlbumArtist },
+	// Not standard, but some taggers write it.
+	"ALBUM ARTIST": func(f *Metadata) *string { return &f.AlbumArtist },
+}
+
+// walkVorbisComment calls `f` with the upper-case name and the value of each
+// field in the Vorbis comment `data`, as found in FLAC VORBIS_COMMENT blocks and
+// Ogg comment headers.
+//
+// Refer to https://xiph.org/vorbis/doc/v-comment.html
+func walkVorbisComment(data []byte, f func(name, value string) error) error {
+	r := fieldReader{data: data, order: binary.LittleEndian}
+	r.bytes() // The vendor string.
+	count := r.uint32()
+	for i := uint32(0); i < count && r.e == nil; i++ {
+		comment := string(r.bytes())
+		equals := strings.IndexByte(comment, '=')
+		if r.e != nil || equals < 0 {
+			continue
+		}
+		if e := f(strings.ToUpper(comment[:equals]), strings.TrimSpace(comment[equals+1:])); e != nil {
+			return e
+		}
+	}
+	return r.e
+}
+
+// parseVorbisComment parses the Vorbis comment `data`. Fields that appear more
+// than once, such as several artists, are joined with ", ". Track and disc
+// totals are appended to the numbers as in ID3, e.g. "3/12".
+func parseVorbisComment(data []byte) (*Metadata, error) {
+	file := new(Metadata)
+	var trackTotal, discTotal string
+	e := walkVorbisComment(data, func(name, value string) error {
+		switch name {
+		case "TRACKTOTAL", "TOTALTRACKS":
+			trackTotal = value
+			return nil
+		case "DISCTOTAL", "TOTALDISCS":
+			discTotal = value
+			return nil
+		case "COMPILATION":
+			file.Compilation = value == "1"
+			return nil
+		}
+		field, ok := vorbisCommentFields[name]
+		if !ok || value == "" {
+			return nil
+		}
+		if p := field(file); *p == "" {
+			*p = value
+		} else {
+			*p += ", " + value
+		}
+		return nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	for _, t := range []struct {
+		number *string
+		total  string
+	}{{&file.Track, trackTotal}, {&file.Disc, discTotal}} {
+		if *t.number != "" && t.total != "" && !strings.Contains(*t.number, "/") {
+			*t.number += "/" + t.total
+		}
+	}
+	return file, nil
+}
+
+// parseFLACPicture parses a FLAC PICTURE block, which is also the format of
+// Ogg METADATA_BLOCK_PICTURE comments.
+//
+// Refer to https://xiph.org/flac/format.html#metadata_block_picture
+func parseFLACPicture(data []byte) (embeddedPicture, error) {
+	f := fieldReader{data: data, order: binary.BigEndian}
+	var p embeddedPicture
+	p.Type = f.uint32()
+	p.MIMEType = string(f.bytes())
+	f.bytes() // The description.
+	for i := 0; i < 4; i++ {
+		f.uint32() // Width, height, color depth, and number of colors.
+	}
+	p.Data = f.bytes()
+	return p, f.e
+}
+
+// FLAC metadata block types.
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+// flacReader reads FLAC VORBIS_COMMENT and PICTURE blocks.
+type flacReader struct{}
+
+func (flacReader) readTags(r io.ReadSeeker, size int64) (*Metadata, error) {
+	var file *Metadata
+	var p AudioProperties
+	_, e := walkFLACMetadata(r, size, func(kind byte, start, end int64) error {
+		switch kind {
+		case flacStreamInfo:
+			if end-start >= 34 {
+				data, e := readBlock(r, start, end)
+				if e != nil {
+					return e
+				}
+				p = parseFLACStreamInfo(data)
+			}
+		case flacVorbisComment:
+			data, e := readBlock(r, start, end)
+			if e != nil {
+				return e
+			}
+			file, e = parseVorbisComment(data)
+			return e
+		}
+		return nil
+	})
+	if e != nil || file == nil {
+		return nil, e
+	}
+	if p.Duration > 0 {
+		// In milliseconds, as in the ID3 TLEN frame.
+		file.Length = strconv.Itoa(int(p.Duration * 1000))
+	}
+	return file, nil
+}
+
+func (flacReader) readPictures(r io.ReadSeeker, size int64) ([]embeddedPicture, error) {
+	var pictures []embeddedPicture
+	_, e := walkFLACMetadata(r, size, func(kind byte, start, end int64) error {
+		if kind != flacPicture {
+			return nil
+		}
+		data, e := readBlock(r, start, end)
+		if e != nil {
+			return e
+		}
+		p, e := parseFLACPicture(data)
+		if e != nil {
+			return e
+		}
+		pictures = append(pictures, p)
+		return nil
+	})
+	return pictures, e
+}
+
+// mp4Reader reads iTunes metadata from MP4 and QuickTime files.
+type mp4Reader struct{}
+
+// walkMP4Items calls `f` with the name, data type, and the extent of the value
+// of each item in the iTunes metadata list, `moov/udta/meta/ilst`, in `r`.
+//
+// Refer to
+// https://developer.apple.com/documentation/quicktime-file-format/metadata_item_list_atom
+func walkMP4Items(r io.ReadSeeker, size int64, f func(name string, dataType uint32, start, end int64) error) error {
+	var visit func(kind string, start, end int64) error
+	visit = func(kind string, start, end int64) error {
+		switch kind {
+		case "moov", "udta":
+			return readMP4Atoms(r, start, end, visit)
+		case "meta":
+			// In MP4 files, but not QuickTime files, `meta` has a version and flags
+			// before its children.
+			var h [8]byte
+			if e := readAt(r, start, h[:]); e != nil {
+				return e
+			}
+			if string(h[4:]) != "hdlr" {
+				start += 4
+			}
+			return readMP4Atoms(r, start, end, visit)
+		case "ilst":
+			return readMP4Atoms(r, start, end, func(name string, start, end int64) error {
+				return readMP4Atoms(r, start, end, func(kind string, start, end int64) error {
+					// A version, 24 bits of type, and a locale come before the value.
+					var h [8]byte
+					if kind != "data" || end-start < 8 {
+						return nil
+					}
+					if e := readAt(r, start, h[:]); e != nil {
+						return e
+					}
+					return f(name, binary.BigEndian.Uint32(h[:4])&0xffffff, start+8, end)
+				})
+			})
+		}
+		return nil
+	}
+	return readMP4Atoms(r, 0, size, visit)
+}
+
+// parseMP4Number parses the value of a `trkn` or `disk` item, which is a
+// number and a total, as in ID3: "3/12".
+func parseMP4Number(data []byte) string {
+	if len(data) < 6 {
+		return ""
+	}
+	number, total := binary.BigEndian.Uint16(data[2:]), binary.BigEndian.Uint16(data[4:])
+	if number == 0 {
+		return ""
+	}
+	if total == 0 {
+		return strconv.Itoa(int(number))
+	}
+	return fmt.Sprintf("%d/%d", number, total)
+}
+
+// mp4TextItems maps the names of MP4 text items to the fields of `Metadata`.
+var mp4TextItems = map[string]func(*Metadata) *string{
+	"\xa9nam": func(f *Metadata) *string { return &f.Name },
+	"\xa9ART": func(f *Metadata) *string { return &f.Artist },
+	"aART":    func(f *Metadata) *string { return &f.AlbumArtist },
+	"\xa9alb": func(f *Metadata) *string { return &f.Album },
+	"\xa9day": func(f *Metadata) *string { return &f.Year },
+	"\xa9gen": func(f *Metadata) *string { return &f.Genre },
+}
+
+func (mp4Reader) readTags(r io.ReadSeeker, size int64) (*Metadata, error) {
+	var file *Metadata
+	e := walkMP4Items(r, size, func(name string, dataType uint32, start, end int64) error {
+		if name == "covr" {
+			return nil
+		}
+		data, e := readBlock(r, start, end)
+		if e != nil {
+			return e
+		}
+		if file == nil {
+			file = new(Metadata)
+		}
+		switch name {
+		case "trkn":
+			file.Track = parseMP4Number(data)
+		case "disk":
+			file.Disc = parseMP4Number(data)
+		case "cpil":
+			file.Compilation = len(data) > 0 && data[0] != 0
+		default:
+			if field, ok := mp4TextItems[name]; ok {
+				*field(file) = strings.TrimSpace(string(data))
+			}
+		}
+		return nil
+	})
+	if e != nil || file == nil {
+		return nil, e
+	}
+	if p, e := readMP4Properties(r, size); e == nil && p.Duration > 0 {
+		file.Length = strconv.Itoa(int(p.Duration * 1000))
+	}
+	return file, nil
+}
+
+// MP4 data types of `covr` items.
+var mp4PictureTypes = map[uint32]string{
+	13: "image/jpeg",
+	14: "image/png",
+	27: "image/bmp",
+}
+
+func (mp4Reader) readPictures(r io.ReadSeeker, size int64) ([]embeddedPicture, error) {
+	var pictures []embeddedPicture
+	e := walkMP4Items(r, size, func(name string, dataType uint32, start, end int64) error {
+		if name != "covr" {
+			return nil
+		}
+		data, e := readBlock(r, start, end)
+		if e != nil {
+			return e
+		}
+		// MP4 files don't say what the pictures are, but the first is the cover.
+		pictures = append(pictures, embeddedPicture{frontCoverPicture, mp4PictureTypes[dataType], data})
+		return nil
+	})
+	return pictures, e
+}
+
+// oggReader reads the comment headers of Ogg Vorbis and Opus streams.
+type oggReader struct{}
+
+// readOggPackets returns the first `count` packets of the first logical stream
+// in `r`, or fewer if the stream ends first. Packets can span pages.
+//
+// Refer to https://xiph.org/ogg/doc/framing.html
+func readOggPackets(r io.ReadSeeker, size int64, count int) ([][]byte, error) {
+	first, e := readOggPage(r, 0)
+	if e != nil {
+		return nil, e
+	}
+	var packets [][]byte
+	var packet []byte
+	for offset := int64(0); offset < size && len(packets) < count; {
+		page, e := readOggPage(r, offset)
+		if e != nil {
+			return nil, e
+		}
+		offset = page.end
+		if page.serial != first.serial {
+			continue
+		}
+		body, e := readBlock(r, page.start, page.end)
+		if e != nil {
+			return nil, e
+		}
+		for _, n := range page.segments {
+			packet = append(packet, body[:n]...)
+			body = body[n:]
+			if len(packet) > maxTagSize {
+				return nil, errMalformedTags
+			}
+			// A lacing value less than 255 ends the packet.
+			if n < 255 {
+				packets = append(packets, packet)
+				packet = nil
+				if len(packets) == count {
+					break
+				}
+			}
+		}
+	}
+	return packets, nil
+}
+
+// readOggComment returns the Vorbis comment in the comment header, which is the
+// second packet, of the Vorbis or Opus stream in `r`. It returns nil if there is
+// none.
+//
+// Refer to https://xiph.org/vorbis/doc/Vorbis_I_spec.html#x1-610004.2 and
+// https://www.rfc-editor.org/rfc/rfc7845#section-5.2
+func readOggComment(r io.ReadSeeker, size int64) ([]byte, error) {
+	packets, e := readOggPackets(r, size, 2)
+	if e != nil || len(packets) < 2 {
+		return nil, e
+	}
+	for _, prefix := range []string{"\x03vorbis", "OpusTags"} {
+		if bytes.HasPrefix(packets[1], []byte(prefix)) {
+			return packets[1][len(prefix):], nil
+		}
+	}
+	return nil, nil
+}
+
+func (oggReader) readTags(r io.ReadSeeker, size int64) (*Metadata, error) {
+	comment, e := readOggComment(r, size)
+	if e != nil || comment == nil {
+		return nil, e
+	}
+	file, e := parseVorbisComment(comment)
+	if e != nil {
+		return nil, e
+	}
+	if p, e := readOggProperties(r, size); e == nil && p.Duration > 0 {
+		file.Length = strconv.Itoa(int(p.Duration * 1000))
+	}
+	return file, nil
+}
+
+// readOggPictures returns the pictures in the METADATA_BLOCK_PICTURE comments
+// of `r`, which are base64-encoded FLAC PICTURE blocks.
+func (oggReader) readPictures(r io.ReadSeeker, size int64) ([]embeddedPicture, error) {
+	comment, e := readOggComment(r, size)
+	if e != nil || comment == nil {
+		return nil, e
+	}
+	var pictures []embeddedPicture
+	e = walkVorbisComment(comment, func(name, value string) error {
+		if name != "METADATA_BLOCK_PICTURE" {
+			return nil
+		}
+		data, e := base64.StdEncoding.DecodeString(value)
+		if e != nil {
+			return errMalformedTags
+		}
+		p, e := parseFLACPicture(data)
+		if e != nil {
+			return e
+		}
+		pictures = append(pictures, p)
+		return nil
+	})
+	return pictures, e
+}
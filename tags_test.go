@@ -0,0 +1,281 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"log"
+	"net/http/httptest"
+	"path"
+	"testing"
+)
+
+func makeVorbisComment(comments ...string) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(6))
+	b.WriteString("vendor")
+	binary.Write(&b, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		binary.Write(&b, binary.LittleEndian, uint32(len(c)))
+		b.WriteString(c)
+	}
+	return b.Bytes()
+}
+
+func makeFLACPicture(kind uint32, mimeType string, data []byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, kind)
+	binary.Write(&b, binary.BigEndian, uint32(len(mimeType)))
+	b.WriteString(mimeType)
+	binary.Write(&b, binary.BigEndian, []uint32{0, 1, 1, 24, 0})
+	binary.Write(&b, binary.BigEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
+
+// makeFLAC returns a 3 second FLAC file with the given metadata blocks after
+// the STREAMINFO block.
+func makeFLAC(blocks map[byte][]byte) []byte {
+	streamInfo := make([]byte, 34)
+	binary.BigEndian.PutUint64(streamInfo[10:], uint64(44100)<<44|uint64(2-1)<<41|uint64(16-1)<<36|44100*3)
+	data := []byte("fLaC")
+	kinds := []byte{flacStreamInfo}
+	contents := [][]byte{streamInfo}
+	for _, kind := range []byte{flacVorbisComment, flacPicture} {
+		if block, ok := blocks[kind]; ok {
+			kinds, contents = append(kinds, kind), append(contents, block)
+		}
+	}
+	for i, kind := range kinds {
+		if i == len(kinds)-1 {
+			kind |= 0x80
+		}
+		n := len(contents[i])
+		data = append(data, kind, byte(n>>16), byte(n>>8), byte(n))
+		data = append(data, contents[i]...)
+	}
+	return append(data, make([]byte, 1000)...)
+}
+
+func TestReadFLACTags(t *testing.T) {
+	data := makeFLAC(map[byte][]byte{
+		flacVorbisComment: makeVorbisComment("TITLE=Hells Bells", "artist=AC/DC", "ALBUM=Back in Black", "DATE=1980-07-25", "TRACKNUMBER=1", "TRACKTOTAL=10", "DISCNUMBER=1", "GENRE=Rock", "GENRE=Hard Rock", "NOTAFIELD"),
+		flacPicture:       makeFLACPicture(frontCoverPicture, "image/png", []byte("a picture")),
+	})
+	file, e := readTags("a.flac", bytes.NewReader(data), int64(len(data)))
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := Metadata{Format: "FLAC", Name: "Hells Bells", Artist: "AC/DC", Album: "Back in Black", Year: "1980-07-25", Track: "1/10", Disc: "1", Genre: "Rock, Hard Rock", Length: "3000"}
+	if file == nil || *file != expected {
+		t.Errorf("expected %+v, got %+v", expected, file)
+	}
+
+	picture, e := readCoverPicture("a.flac", bytes.NewReader(data), int64(len(data)))
+	if e != nil || picture == nil || picture.MIMEType != "image/png" || string(picture.Data) != "a picture" {
+		t.Errorf("expected the picture, got %+v, %v", picture, e)
+	}
+
+	// No comment block means no tags; a malformed one is an error.
+	data = makeFLAC(nil)
+	if file, e := readTags("a.flac", bytes.NewReader(data), int64(len(data))); file != nil || e != nil {
+		t.Errorf("expected no tags, got %+v, %v", file, e)
+	}
+	data = makeFLAC(map[byte][]byte{flacVorbisComment: makeVorbisComment("TITLE=x")[:20]})
+	if _, e := readTags("a.flac", bytes.NewReader(data), int64(len(data))); e == nil {
+		t.Error("expected an error for a truncated comment")
+	}
+	if file, e := readTags("junk.flac", bytes.NewReader([]byte("junk")), 4); file != nil || e != nil {
+		t.Errorf("expected no tags in junk, got %+v, %v", file, e)
+	}
+	data = makeFLAC(map[byte][]byte{flacVorbisComment: makeVorbisComment("TITLE=x")})
+	if _, e := readTags("a.flac", bytes.NewReader(data[:60]), 60); e == nil {
+		t.Error("expected an error for a truncated file")
+	}
+}
+
+func TestNewCatalogRecordsTruncatedTags(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	data := makeFLAC(map[byte][]byte{flacVorbisComment: makeVorbisComment("TITLE=Real Name")})
+	writeTestFile(t, path.Join(root, "Artist/Album/01 Guess.flac"), string(data[:60]))
+
+	c, report := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 1})
+	if len(c.ItemInfos) != 1 || c.ItemInfos[0].Name != "Guess" {
+		t.Errorf("expected the item to be kept, got %+v", c.ItemInfos)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].Stage != scanStageTags {
+		t.Errorf("expected a tags error, got %+v", report.Errors)
+	}
+}
+
+func TestNewCatalogReadsFLACTags(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	data := makeFLAC(map[byte][]byte{
+		flacVorbisComment: makeVorbisComment("TITLE=Real Name", "ARTIST=Real Artist", "TRACKNUMBER=7"),
+		flacPicture:       makeFLACPicture(frontCoverPicture, "image/jpeg", []byte("cover")),
+	})
+	writeTestFile(t, path.Join(root, "Folder/Album/01 Guess.flac"), string(data))
+
+	c, _ := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 1})
+	if len(c.ItemInfos) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(c.ItemInfos))
+	}
+	item := c.ItemInfos[0]
+	if item.Name != "Real Name" || item.Artist != "Real Artist" || item.Track != "7" || item.Album != "Album" {
+		t.Errorf("expected the tags to win, got %+v", item)
+	}
+
+	h := &httpHandler{Roots: musicRoots{{Pathname: root}}, Catalog: newLiveCatalog(c), Logger: logger}
+	w := httptest.NewRecorder()
+	h.serveFile(w, httptest.NewRequest("GET", "/Folder/Album/cover", nil))
+	if w.Body.String() != "cover" || w.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("expected the embedded cover, got %q (%s)", w.Body.String(), w.Header().Get("Content-Type"))
+	}
+}
+
+// makeMP4Item returns an iTunes metadata item named `name` with one value.
+func makeMP4Item(name string, dataType uint32, value []byte) []byte {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, dataType)
+	return makeMP4Atom(name, makeMP4Atom("data", header, value))
+}
+
+func TestReadMP4Tags(t *testing.T) {
+	data := bytes.Join([][]byte{
+		makeMP4Atom("ftyp", []byte("M4A \x00\x00\x00\x00")),
+		makeMP4Atom("moov",
+			makeMP4Atom("udta",
+				makeMP4Atom("meta", make([]byte, 4),
+					makeMP4Atom("hdlr", make([]byte, 25)),
+					makeMP4Atom("ilst",
+						makeMP4Item("\xa9nam", 1, []byte("Hells Bells")),
+						makeMP4Item("\xa9ART", 1, []byte("AC/DC")),
+						makeMP4Item("aART", 1, []byte("AC/DC")),
+						makeMP4Item("\xa9alb", 1, []byte("Back in Black")),
+						makeMP4Item("trkn", 0, []byte{0, 0, 0, 1, 0, 10, 0, 0}),
+						makeMP4Item("disk", 0, []byte{0, 0, 0, 1, 0, 0}),
+						makeMP4Item("cpil", 21, []byte{1}),
+						makeMP4Item("covr", 14, []byte("a picture")))))),
+	}, nil)
+	file, e := readTags("a.m4a", bytes.NewReader(data), int64(len(data)))
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := Metadata{Format: "MP4", Name: "Hells Bells", Artist: "AC/DC", Album: "Back in Black", Track: "1/10", Disc: "1", AlbumArtist: "AC/DC", Compilation: true}
+	if file == nil || *file != expected {
+		t.Errorf("expected %+v, got %+v", expected, file)
+	}
+
+	picture, e := readCoverPicture("a.m4a", bytes.NewReader(data), int64(len(data)))
+	if e != nil || picture == nil || picture.MIMEType != "image/png" || string(picture.Data) != "a picture" {
+		t.Errorf("expected the picture, got %+v, %v", picture, e)
+	}
+
+	data = makeMP4Atom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	if file, e := readTags("a.m4a", bytes.NewReader(data), int64(len(data))); file != nil || e != nil {
+		t.Errorf("expected no tags, got %+v, %v", file, e)
+	}
+}
+
+func TestNewAlbumUsesAlbumArtist(t *testing.T) {
+	items := ItemInfos{
+		{Pathname: "a/1.m4a", Artist: "One", Tags: &Metadata{AlbumArtist: "Both"}},
+		{Pathname: "a/2.m4a", Artist: "Two", Tags: &Metadata{AlbumArtist: "Both"}},
+	}
+	if a := newAlbum("a", items); a.Artist != "Both" {
+		t.Errorf("expected the album artist, got %q", a.Artist)
+	}
+	items = ItemInfos{
+		{Pathname: "a/1.m4a", Artist: "One", Tags: &Metadata{Compilation: true}},
+		{Pathname: "a/2.m4a", Artist: "One"},
+	}
+	if a := newAlbum("a", items); a.Artist != variousArtists {
+		t.Errorf("expected %q for a compilation, got %q", variousArtists, a.Artist)
+	}
+}
+
+// makeOggHeaders returns the Ogg pages of the header `packets`, with at most 4
+// segments on a page, so that long packets span pages.
+func makeOggHeaders(packets ...[]byte) []byte {
+	var segments []byte
+	var body []byte
+	for _, p := range packets {
+		for n := len(p); ; n -= 255 {
+			if n < 255 {
+				segments = append(segments, byte(n))
+				break
+			}
+			segments = append(segments, 255)
+		}
+		body = append(body, p...)
+	}
+	var b bytes.Buffer
+	for len(segments) > 0 {
+		count := len(segments)
+		if count > 4 {
+			count = 4
+		}
+		length := 0
+		for _, n := range segments[:count] {
+			length += int(n)
+		}
+		b.WriteString("OggS\x00\x00")
+		binary.Write(&b, binary.LittleEndian, uint64(0))
+		binary.Write(&b, binary.LittleEndian, []uint32{1234, 0, 0})
+		b.WriteByte(byte(count))
+		b.Write(segments[:count])
+		b.Write(body[:length])
+		segments, body = segments[count:], body[length:]
+	}
+	return b.Bytes()
+}
+
+func TestReadOggTags(t *testing.T) {
+	picture := base64.StdEncoding.EncodeToString(makeFLACPicture(frontCoverPicture, "image/jpeg", bytes.Repeat([]byte("cover"), 500)))
+	comment := makeVorbisComment("TITLE=Hells Bells", "ARTIST=AC/DC", "TRACKNUMBER=1", "METADATA_BLOCK_PICTURE="+picture)
+
+	var identification bytes.Buffer
+	identification.WriteString("\x01vorbis\x00\x00\x00\x00\x01")
+	binary.Write(&identification, binary.LittleEndian, []uint32{44100, 0, 64000, 0})
+	identification.WriteString("\xb8\x01")
+	vorbis := makeOggHeaders(identification.Bytes(), append([]byte("\x03vorbis"), append(comment, 1)...), []byte("\x05vorbis"))
+	vorbis = append(vorbis, makeOggPage(4, 44100*5, make([]byte, 200))...)
+
+	opusHead := []byte("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	opus := makeOggHeaders(opusHead, append([]byte("OpusTags"), comment...))
+	opus = append(opus, makeOggPage(4, 48000*3+312, make([]byte, 200))...)
+
+	for _, test := range []struct {
+		pathname string
+		data     []byte
+		length   string
+	}{
+		{"a.ogg", vorbis, "5000"},
+		{"a.opus", opus, "3000"},
+	} {
+		file, e := readTags(test.pathname, bytes.NewReader(test.data), int64(len(test.data)))
+		if e != nil {
+			t.Fatalf("%s: %v", test.pathname, e)
+		}
+		expected := Metadata{Format: "Ogg", Name: "Hells Bells", Artist: "AC/DC", Track: "1", Length: test.length}
+		if file == nil || *file != expected {
+			t.Errorf("%s: expected %+v, got %+v", test.pathname, expected, file)
+		}
+		p, e := readCoverPicture(test.pathname, bytes.NewReader(test.data), int64(len(test.data)))
+		if e != nil || p == nil || p.MIMEType != "image/jpeg" || len(p.Data) != 2500 {
+			t.Errorf("%s: expected the picture, got %+v, %v", test.pathname, p, e)
+		}
+	}
+
+	// A stream that ends before its comment header has no tags.
+	data := makeOggHeaders(opusHead)
+	if file, e := readTags("a.opus", bytes.NewReader(data), int64(len(data))); file != nil || e != nil {
+		t.Errorf("expected no tags, got %+v, %v", file, e)
+	}
+}
@@ -31,6 +31,7 @@ var (
 		".midi",
 		".mp3",
 		".ogg",
+		".opus",
 		".wav",
 		".wave",
 	}
@@ -0,0 +1,181 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// A treeWalker walks directory trees in the same order, and with the same
+// calls to its `filepath.WalkFunc`, as `filepath.Walk`.
+//
+// If `follow` is set, it also follows symbolic links, as long as they lead
+// somewhere inside the roots. Each file and directory is visited only once,
+// at the first pathname that leads to it, so that the same target is not
+// cataloged twice. A link to a directory that contains the link is reported
+// as an error, rather than followed forever.
+//
+// A walker remembers what it has visited across calls to `Walk`, so the
+// watcher uses one walker for all its batches of changes. Walking the first
+// pathname again visits it again, and so does any other pathname once the
+// first no longer leads to the same file.
+type treeWalker struct {
+	follow bool
+	roots  []string
+	// The first pathname that led to each file and directory.
+	visited   map[fileID]string
+	ancestors map[fileID]bool
+}
+
+func newTreeWalker(roots musicRoots, follow bool) *treeWalker {
+	w := treeWalker{follow: follow, visited: map[fileID]string{}, ancestors: map[fileID]bool{}}
+	if follow {
+		for _, r := range roots {
+			if r.realPathname != "" {
+				w.roots = append(w.roots, r.realPathname)
+			} else if real, e := filepath.EvalSymlinks(r.Pathname); e == nil {
+				w.roots = append(w.roots, real)
+			}
+		}
+	}
+	return &w
+}
+
+// remember marks the files of the items in `c`, which are in `roots`, and the
+// directories they are in, as visited, as if `w` had walked the trees that `c`
+// was made from.
+func (w *treeWalker) remember(roots musicRoots, c *Catalog) {
+	if !w.follow {
+		return
+	}
+	seen := map[string]bool{}
+	for i := range c.ItemInfos {
+		// Stop at the root, or failing that, at the top of the file system.
+		for p := roots.getItemFilePathname(&c.ItemInfos[i]); p != "" && !seen[p] && !roots.isRoot(p); p = filepath.Dir(p) {
+			seen[p] = true
+			if info, e := os.Stat(p); e == nil {
+				if id, ok := getFileID(p, info); ok {
+					w.visited[id] = p
+				}
+			}
+		}
+	}
+}
+
+// wasVisitedElsewhere returns true if the file with `id`, now at `pathname`,
+// was visited at some other pathname that still leads to it.
+func (w *treeWalker) wasVisitedElsewhere(id fileID, pathname string) bool {
+	first, ok := w.visited[id]
+	if !ok || first == pathname {
+		return false
+	}
+	info, e := os.Stat(first)
+	if e != nil {
+		return false
+	}
+	firstID, ok := getFileID(first, info)
+	return ok && firstID == id
+}
+
+// Walk walks the tree at `root`, calling `fn` for each file and directory.
+func (w *treeWalker) Walk(root string, fn filepath.WalkFunc) error {
+	stat := os.Lstat
+	if w.follow {
+		stat = os.Stat
+	}
+	info, e := stat(root)
+	if e != nil {
+		e = fn(root, nil, e)
+	} else {
+		e = w.walk(root, info, fn)
+	}
+	if e == filepath.SkipDir {
+		return nil
+	}
+	return e
+}
+
+func readDirNames(pathname string) ([]string, error) {
+	dir, e := os.Open(pathname)
+	if e != nil {
+		return nil, e
+	}
+	names, e := dir.Readdirnames(-1)
+	dir.Close()
+	if e != nil {
+		return nil, e
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// followLink returns information about the target of the symbolic link at
+// `pathname`. If the link cannot be followed, it returns `link` and an error.
+func (w *treeWalker) followLink(pathname string, link os.FileInfo) (os.FileInfo, error) {
+	target, e := filepath.EvalSymlinks(pathname)
+	if e != nil {
+		return link, e
+	}
+	within := false
+	for _, root := range w.roots {
+		within = within || isFilePathnameWithin(target, root)
+	}
+	if !within {
+		return link, errors.New("links outside the music directories, to " + target)
+	}
+	info, e := os.Stat(target)
+	if e != nil {
+		return link, e
+	}
+	if id, ok := getFileID(target, info); ok && info.IsDir() && w.ancestors[id] {
+		return link, errors.New("links to a directory that contains it, " + target)
+	}
+	return info, nil
+}
+
+func (w *treeWalker) walk(pathname string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if w.follow {
+		if id, ok := getFileID(pathname, info); ok {
+			if w.wasVisitedElsewhere(id, pathname) {
+				return nil
+			}
+			w.visited[id] = pathname
+			if info.IsDir() {
+				w.ancestors[id] = true
+				defer delete(w.ancestors, id)
+			}
+		}
+	}
+
+	if !info.IsDir() {
+		return fn(pathname, info, nil)
+	}
+	names, e := readDirNames(pathname)
+	e1 := fn(pathname, info, e)
+	if e != nil || e1 != nil {
+		return e1
+	}
+	for _, name := range names {
+		filename := filepath.Join(pathname, name)
+		fileInfo, e := os.Lstat(filename)
+		if e == nil && w.follow && fileInfo.Mode()&os.ModeSymlink != 0 {
+			fileInfo, e = w.followLink(filename, fileInfo)
+		}
+		if e != nil {
+			if e := fn(filename, fileInfo, e); e != nil && e != filepath.SkipDir {
+				return e
+			}
+			continue
+		}
+		if e := w.walk(filename, fileInfo, fn); e != nil {
+			if !fileInfo.IsDir() || e != filepath.SkipDir {
+				return e
+			}
+		}
+	}
+	return nil
+}
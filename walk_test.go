@@ -0,0 +1,145 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makeSymlink(t *testing.T, target, pathname string) {
+	t.Helper()
+	if e := os.Symlink(target, pathname); e != nil {
+		t.Skip(e)
+	}
+}
+
+func TestTreeWalkerMatchesWalk(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, path.Join(root, "A/Album/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(root, "A/Album/02 Two.mp3"), "two")
+	writeTestFile(t, path.Join(root, "B/01 One.mp3"), "one")
+	makeSymlink(t, path.Join(root, "A"), path.Join(root, "C"))
+
+	var expected, got []string
+	_ = filepath.Walk(root, func(pathname string, info os.FileInfo, e error) error {
+		expected = append(expected, pathname)
+		return nil
+	})
+	_ = newTreeWalker(musicRoots{{Pathname: root}}, false).Walk(root, func(pathname string, info os.FileInfo, e error) error {
+		got = append(got, pathname)
+		return nil
+	})
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestNewCatalogFollowSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	writeTestFile(t, path.Join(root, "The Artist/Album/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(root, "The Artist/Other/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(outside, "Album/01 One.mp3"), "one")
+	if e := os.MkdirAll(path.Join(root, "Collections/Favorites"), 0755); e != nil {
+		t.Fatal(e)
+	}
+	// Sorts first, so the album is cataloged under this pathname only.
+	makeSymlink(t, "../../The Artist/Album", path.Join(root, "Collections/Favorites/Album"))
+	makeSymlink(t, "../../The Artist/Other/01 One.mp3", path.Join(root, "Collections/Favorites/One.mp3"))
+	makeSymlink(t, "..", path.Join(root, "Collections/Favorites/Loop"))
+	makeSymlink(t, path.Join(outside, "Album"), path.Join(root, "Collections/Outside"))
+	makeSymlink(t, "Nowhere", path.Join(root, "Collections/Dangling"))
+
+	c, _ := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 2})
+	if len(c.ItemInfos) != 2 {
+		t.Errorf("without following links, expected 2 items, got %d", len(c.ItemInfos))
+	}
+
+	c, report := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 2, FollowSymlinks: true})
+	expected := []string{
+		"Collections/Favorites/Album/01%20One.mp3",
+		"Collections/Favorites/One.mp3",
+	}
+	if len(c.ItemInfos) != len(expected) {
+		t.Fatalf("expected %d items, got %d", len(expected), len(c.ItemInfos))
+	}
+	for i, pathname := range expected {
+		if c.ItemInfos[i].Pathname != pathname {
+			t.Errorf("%d: expected %q, got %q", i, pathname, c.ItemInfos[i].Pathname)
+		}
+	}
+
+	failed := map[string]bool{}
+	for _, e := range report.Errors {
+		failed[path.Base(e.Pathname)] = true
+	}
+	for _, name := range []string{"Dangling", "Loop", "Outside"} {
+		if !failed[name] {
+			t.Errorf("expected an error for %q, got %v", name, report.Errors)
+		}
+	}
+}
+
+func TestMusicRootsAllows(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeTestFile(t, path.Join(root, "Album/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(outside, "secret.txt"), "secret")
+	makeSymlink(t, "Album", path.Join(root, "Linked"))
+	makeSymlink(t, outside, path.Join(root, "Outside"))
+	roots := musicRoots{{Pathname: root}}
+
+	for pathname, expected := range map[string]bool{
+		path.Join(root, "Album/01 One.mp3"):   true,
+		path.Join(root, "Linked/01 One.mp3"):  true,
+		path.Join(root, "Outside/secret.txt"): false,
+		path.Join(root, "Missing.mp3"):        false,
+	} {
+		if got := roots.allows(pathname); got != expected {
+			t.Errorf("%q: expected %v, got %v", pathname, expected, got)
+		}
+	}
+
+	// A root that is itself a symbolic link is resolved when it is parsed.
+	link := path.Join(outside, "Music")
+	makeSymlink(t, root, link)
+	roots = musicRoots{parseMusicRoot(link)}
+	if roots[0].realPathname == "" || roots[0].realPathname == link {
+		t.Errorf("expected the root to be resolved, got %q", roots[0].realPathname)
+	}
+	if !roots.allows(path.Join(link, "Album/01 One.mp3")) || roots.allows(path.Join(outside, "secret.txt")) {
+		t.Error("unexpected access through a linked root")
+	}
+}
+
+func TestWithChangesRemembersVisitedFiles(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	roots := musicRoots{{Pathname: root}}
+	options := scanOptions{Workers: 1, FollowSymlinks: true}
+	writeTestFile(t, path.Join(root, "Artist/Album/01 One.mp3"), "one")
+	c, _ := newCatalog(logger, roots, nil, options)
+
+	walker := newTreeWalker(roots, true)
+	walker.remember(roots, c)
+	makeSymlink(t, "Artist/Album", path.Join(root, "Linked"))
+	u := c.withChanges(logger, roots, options, walker, []string{path.Join(root, "Linked")})
+	if len(u.ItemInfos) != 1 || u.ItemInfos[0].Pathname != "Artist/Album/01%20One.mp3" {
+		t.Errorf("expected the linked album to be cataloged once, got %+v", u.ItemInfos)
+	}
+
+	// The file's own pathname can still be walked again, as when it changes.
+	writeTestFile(t, path.Join(root, "Artist/Album/01 One.mp3"), "changed")
+	u = u.withChanges(logger, roots, options, walker, []string{path.Join(root, "Artist/Album")})
+	if len(u.ItemInfos) != 1 || u.ItemInfos[0].Size != int64(len("changed")) {
+		t.Errorf("expected the changed item, got %+v", u.ItemInfos)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// How long the watcher waits for the file system to settle down before it
+	// updates the catalog.
+	watchQuietPeriod = 2 * time.Second
+
+	// How often the watcher writes the live catalog to disk, if it has changed.
+	watchPersistInterval = 5 * time.Minute
+)
+
+// splitPathname splits an escaped web pathname into its unescaped components.
+func splitPathname(pathname string) []string {
+	return strings.Split(unescapePathname(pathname), "/")
+}
+
+// walkOrderLess returns true if `filepath.Walk` visits the file at escaped web
+// pathname `a` before that at `b`.
+func walkOrderLess(a, b string) bool {
+	as, bs := splitPathname(a), splitPathname(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// isPathnameWithin returns true if `pathname` is `dir`, or is inside `dir`.
+func isPathnameWithin(pathname, dir string) bool {
+	return dir == "" || pathname == dir || strings.HasPrefix(pathname, dir+"/")
+}
+
+// isPathnameWithinAny returns true if `pathname` is within any of `dirs`.
+func isPathnameWithinAny(pathname string, dirs []string) bool {
+	for _, dir := range dirs {
+		if isPathnameWithin(pathname, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// withChanges returns a copy of `c`, updated to reflect the current state of
+// the files and directories at `pathnames`, which are in `roots`. Items for
+// pathnames that no longer exist, or that are now ignored, are removed, and
+// items for new or changed files are (re-)read. `c` itself is not modified.
+// `walker` should be the same for every batch of changes; see `treeWalker`.
+func (c *Catalog) withChanges(log *log.Logger, roots musicRoots, options scanOptions, walker *treeWalker, pathnames []string) *Catalog {
+	current := c.byPathname()
+	// Ignore files may have changed too, so read them afresh.
+	ignores := newIgnoreMatcher(options.IgnoreRules, func(e error) { log.Print(e) })
+	var changed []string
+	added := map[string]ItemInfo{}
+	for _, p := range pathnames {
+		root, ok := roots.find(p)
+		if !ok {
+			continue
+		}
+		changed = append(changed, pathnameEscape(root.getWebPathname(p)))
+		if info, e := os.Stat(p); e != nil || ignores.excludes(root.Pathname, p, info.IsDir()) {
+			continue
+		}
+
+		e := walker.Walk(p,
+			func(pathname string, info os.FileInfo, e error) error {
+				if e != nil {
+					if !os.IsNotExist(e) {
+						log.Print(e)
+					}
+					return nil
+				}
+				if info.IsDir() {
+					if pathname != p && ignores.shouldIgnore(root.Pathname, pathname, true) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if shouldSkipFile(info) || !(isAudioPathname(pathname) || isVideoPathname(pathname)) || ignores.shouldIgnore(root.Pathname, pathname, false) {
+					return nil
+				}
+				webPathname := pathnameEscape(root.getWebPathname(pathname))
+				previous, ok := current[webPathname]
+				if ok && previous.isCurrent(info) {
+					itemInfo, problem := previous, (*scanError)(nil)
+					if previous.ID == "" || previous.MissingProperties {
+						itemInfo, problem = readMissing(pathname, previous)
+					}
+					if problem != nil {
+						log.Print(problem)
+					}
+					if itemInfo != nil {
+						added[webPathname] = *itemInfo
+					}
+					return nil
+				}
+				itemInfo, problem := readItemInfo(root, pathname, info)
+				if problem != nil {
+					log.Print(problem)
+				}
+				if itemInfo != nil && ok {
+					itemInfo.carryOver(previous)
+				}
+				if itemInfo != nil {
+					added[webPathname] = *itemInfo
+				}
+				return nil
+			})
+		if e != nil {
+			log.Print(e)
+		}
+	}
+
+	var kept ItemInfos
+	for _, item := range c.ItemInfos {
+		if !isPathnameWithinAny(item.Pathname, changed) {
+			kept = append(kept, item)
+		}
+	}
+
+	var additions ItemInfos
+	for _, item := range added {
+		additions = append(additions, item)
+	}
+	// The old pathname of a renamed file is usually in the same batch of changes,
+	// so its item is still in `c`. It is gone if the walk didn't find it again.
+	carryOverMoves(additions, c, func(pathname string) bool {
+		_, ok := added[pathname]
+		return !ok && isPathnameWithinAny(pathname, changed)
+	})
+	sort.Slice(additions, func(i, j int) bool {
+		return walkOrderLess(additions[i].Pathname, additions[j].Pathname)
+	})
+
+	// Merge the additions into place, to keep the catalog in walk order.
+	var u Catalog
+	u.ItemInfos = make(ItemInfos, 0, len(kept)+len(additions))
+	i, j := 0, 0
+	for i < len(kept) && j < len(additions) {
+		if walkOrderLess(additions[j].Pathname, kept[i].Pathname) {
+			u.ItemInfos = append(u.ItemInfos, additions[j])
+			j++
+		} else {
+			u.ItemInfos = append(u.ItemInfos, kept[i])
+			i++
+		}
+	}
+	u.ItemInfos = append(u.ItemInfos, kept[i:]...)
+	u.ItemInfos = append(u.ItemInfos, additions[j:]...)
+	u.assignUniqueIDs(c)
+
+	// Only albums in or around the changed pathnames need their files read again.
+	albums := c.byDirectory()
+	for dir := range albums {
+		for _, p := range changed {
+			if isPathnameWithin(dir, p) || path.Dir(p) == dir {
+				delete(albums, dir)
+				break
+			}
+		}
+	}
+	u.aggregate(roots, albums)
+	return &u
+}
+
+// watchCatalog keeps `live` up to date with changes to the files in `roots`,
+// and periodically writes it to `catalogPathname`. It returns an error if the
+// platform does not support watching, or if the watch could not be set up;
+// otherwise it runs in the background.
+func watchCatalog(log *log.Logger, roots musicRoots, options scanOptions, catalogPathname string, live *liveCatalog) error {
+	changes := make(chan string, 1024)
+	for _, root := range roots {
+		if e := watchTree(log, root.Pathname, changes); e != nil {
+			return e
+		}
+	}
+
+	// Share one walker between batches, so that a file already in the catalog
+	// is not cataloged again when a new link to it appears.
+	walker := newTreeWalker(roots, options.FollowSymlinks)
+
+	go func() {
+		walker.remember(roots, live.Load())
+		pending := map[string]bool{}
+		dirty := false
+		quiet := time.NewTimer(watchQuietPeriod)
+		quiet.Stop()
+		persist := time.NewTicker(watchPersistInterval)
+		for {
+			select {
+			case pathname := <-changes:
+				pending[pathname] = true
+				quiet.Reset(watchQuietPeriod)
+			case <-quiet.C:
+				var pathnames []string
+				for p := range pending {
+					pathnames = append(pathnames, p)
+				}
+				sort.Strings(pathnames)
+				pending = map[string]bool{}
+				live.update(func(c *Catalog) *Catalog {
+					return c.withChanges(log, roots, options, walker, pathnames)
+				})
+				log.Printf("Updated catalog for %d changed pathnames", len(pathnames))
+				dirty = true
+			case <-persist.C:
+				if !dirty {
+					continue
+				}
+				if e := live.Load().writeToFile(catalogPathname, false); e != nil {
+					log.Print(e)
+					continue
+				}
+				dirty = false
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// IN_ATTRIB catches `touch`, `chmod`, and `chown`, which change modification
+// times and permissions without writing.
+const inotifyMask = syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+type inotifyWatcher struct {
+	log *log.Logger
+	fd  int
+	// Maps watch descriptors to the directories they watch.
+	directories map[int32]string
+}
+
+// addTree adds watches for `root` and all the directories beneath it.
+func (w *inotifyWatcher) addTree(root string) error {
+	return filepath.Walk(root,
+		func(pathname string, info os.FileInfo, e error) error {
+			if e != nil {
+				if os.IsNotExist(e) {
+					return nil
+				}
+				return e
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			if info.Name() != "" && info.Name()[0] == '.' && pathname != root {
+				return filepath.SkipDir
+			}
+			wd, e := syscall.InotifyAddWatch(w.fd, pathname, inotifyMask)
+			if e != nil {
+				if e == syscall.ENOSPC {
+					w.log.Print("Out of inotify watches; consider raising fs.inotify.max_user_watches")
+				}
+				return &os.PathError{Op: "inotify_add_watch", Path: pathname, Err: e}
+			}
+			w.directories[int32(wd)] = pathname
+			return nil
+		})
+}
+
+func (w *inotifyWatcher) run(root string, changes chan<- string) {
+	buffer := make([]byte, 64*1024)
+	for {
+		n, e := syscall.Read(w.fd, buffer)
+		if e != nil {
+			if e == syscall.EINTR {
+				continue
+			}
+			w.log.Print(os.NewSyscallError("read", e))
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			offset = nameEnd
+			if nameEnd > n {
+				break
+			}
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// We have lost track of what changed, so re-examine everything.
+				w.log.Print("inotify queue overflowed")
+				changes <- root
+				continue
+			}
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.directories, event.Wd)
+				continue
+			}
+			dir, ok := w.directories[event.Wd]
+			if !ok {
+				continue
+			}
+			name := string(bytes.TrimRight(buffer[nameStart:nameEnd], "\x00"))
+			if name == ignoreBasename {
+				// The rules for the whole directory may have changed.
+				changes <- dir
+				continue
+			}
+			if name == "" || name[0] == '.' {
+				continue
+			}
+			pathname := filepath.Join(dir, name)
+
+			if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				if e := w.addTree(pathname); e != nil {
+					w.log.Print(e)
+				}
+			}
+			changes <- pathname
+		}
+	}
+}
+
+// watchTree sends the pathnames of files and directories in the tree rooted at
+// `root` to `changes` as they are created, modified, moved, or deleted.
+func watchTree(log *log.Logger, root string, changes chan<- string) error {
+	fd, e := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if e != nil {
+		return os.NewSyscallError("inotify_init1", e)
+	}
+	w := &inotifyWatcher{log: log, fd: fd, directories: map[int32]string{}}
+	if e := w.addTree(root); e != nil {
+		_ = syscall.Close(fd)
+		return e
+	}
+	go w.run(root, changes)
+	return nil
+}
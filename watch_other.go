@@ -0,0 +1,15 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+func watchTree(log *log.Logger, root string, changes chan<- string) error {
+	return errors.New("watching for changes is not supported on this platform")
+}
@@ -0,0 +1,64 @@
+// Copyright 2026 by Chris Palmer (https://noncombatant.org)
+// SPDX-License-Identifier: GPL-3.0
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestWalkOrderLess(t *testing.T) {
+	ordered := []string{
+		"a/b",
+		"a%20b/c",
+		"a%20b/c/d",
+		"b",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		if !walkOrderLess(ordered[i], ordered[i+1]) {
+			t.Errorf("%q should sort before %q", ordered[i], ordered[i+1])
+		}
+		if walkOrderLess(ordered[i+1], ordered[i]) {
+			t.Errorf("%q should not sort before %q", ordered[i+1], ordered[i])
+		}
+	}
+}
+
+func TestCatalogWithChanges(t *testing.T) {
+	root := t.TempDir()
+	logger := log.New(io.Discard, "", 0)
+	writeTestFile(t, path.Join(root, "A/Album/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(root, "C/Album/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(root, "D/Album/01 One.mp3"), "one")
+
+	c, _ := newCatalog(logger, musicRoots{{Pathname: root}}, nil, scanOptions{Workers: 1})
+
+	writeTestFile(t, path.Join(root, "B/Album/01 One.mp3"), "one")
+	writeTestFile(t, path.Join(root, "B/Album/02 Two.mp3"), "two")
+	if e := os.RemoveAll(path.Join(root, "C")); e != nil {
+		t.Fatal(e)
+	}
+
+	u := c.withChanges(logger, musicRoots{{Pathname: root}}, scanOptions{}, newTreeWalker(nil, false), []string{path.Join(root, "B"), path.Join(root, "C")})
+	expected := []string{
+		"A/Album/01%20One.mp3",
+		"B/Album/01%20One.mp3",
+		"B/Album/02%20Two.mp3",
+		"D/Album/01%20One.mp3",
+	}
+	if len(u.ItemInfos) != len(expected) {
+		t.Fatalf("expected %d items, got %d", len(expected), len(u.ItemInfos))
+	}
+	for i, pathname := range expected {
+		if u.ItemInfos[i].Pathname != pathname {
+			t.Errorf("%d: expected %q, got %q", i, pathname, u.ItemInfos[i].Pathname)
+		}
+	}
+	if len(c.ItemInfos) != 3 {
+		t.Errorf("withChanges modified the original catalog")
+	}
+}
@@ -469,6 +469,7 @@ const audioFormatExtensions = [
   ".midi",
   ".mp3",
   ".ogg",
+  ".opus",
   ".wav",
   ".wave",
 ]